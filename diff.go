@@ -0,0 +1,299 @@
+package main
+
+import (
+	"context"
+	"errors"
+	s "example.com/mod/store"
+	tracer "example.com/mod/tracer"
+	"fmt"
+	"github.com/gorilla/mux"
+	"net/http"
+	"sort"
+)
+
+// EntryChange is the old and new value of a changed key.
+type EntryChange struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// EntriesDiff compares two key/value maps, config entries or labels.
+type EntriesDiff struct {
+	Added   map[string]string      `json:"added"`
+	Removed map[string]string      `json:"removed"`
+	Changed map[string]EntryChange `json:"changed"`
+}
+
+// ConfigDiff is the difference between two versions of a config.
+type ConfigDiff struct {
+	Id      string      `json:"id"`
+	From    string      `json:"from"`
+	To      string      `json:"to"`
+	Entries EntriesDiff `json:"entries"`
+	Labels  EntriesDiff `json:"labels"`
+}
+
+// MemberDiff is a config that is in both versions of a group but differs.
+type MemberDiff struct {
+	Id          string      `json:"id"`
+	FromVersion string      `json:"fromVersion"`
+	ToVersion   string      `json:"toVersion"`
+	Entries     EntriesDiff `json:"entries"`
+	Labels      EntriesDiff `json:"labels"`
+}
+
+// GroupDiff is the difference between two versions of a group. Member
+// configs are matched by id, version and labels, so a group can hold
+// several versions of a config. A config that is left in one version on
+// each side, with the same labels, is reported as changed from the one
+// version to the other.
+type GroupDiff struct {
+	Id      string       `json:"id"`
+	From    string       `json:"from"`
+	To      string       `json:"to"`
+	Labels  EntriesDiff  `json:"labels"`
+	Added   []s.Config   `json:"added"`
+	Removed []s.Config   `json:"removed"`
+	Changed []MemberDiff `json:"changed"`
+}
+
+var errDiffVersions = errors.New("from and to query parameters are required")
+
+func diffEntries(from map[string]string, to map[string]string) EntriesDiff {
+	diff := EntriesDiff{
+		Added:   map[string]string{},
+		Removed: map[string]string{},
+		Changed: map[string]EntryChange{},
+	}
+	for key, value := range from {
+		next, ok := to[key]
+		if !ok {
+			diff.Removed[key] = value
+		} else if next != value {
+			diff.Changed[key] = EntryChange{From: value, To: next}
+		}
+	}
+	for key, value := range to {
+		if _, ok := from[key]; !ok {
+			diff.Added[key] = value
+		}
+	}
+	return diff
+}
+
+func (d EntriesDiff) empty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0 && len(d.Changed) == 0
+}
+
+func diffConfigs(from *s.Config, to *s.Config) ConfigDiff {
+	return ConfigDiff{
+		Id:      from.Id,
+		From:    from.Version,
+		To:      to.Version,
+		Entries: diffEntries(from.Entries, to.Entries),
+		Labels:  diffEntries(from.Labels, to.Labels),
+	}
+}
+
+func diffGroups(from *s.Group, to *s.Group) GroupDiff {
+	diff := GroupDiff{
+		Id:      from.Id,
+		From:    from.Version,
+		To:      to.Version,
+		Labels:  diffEntries(from.Labels, to.Labels),
+		Added:   []s.Config{},
+		Removed: []s.Config{},
+		Changed: []MemberDiff{},
+	}
+
+	// Copies in both versions are the same member, only their entries
+	// can differ.
+	after := map[string][]s.Config{}
+	for _, config := range to.Configs {
+		after[memberKey(config)] = append(after[memberKey(config)], config)
+	}
+	// What is left of a config on both sides, with the same labels,
+	// moved to another version if it is one copy each.
+	removed, added := map[string][]s.Config{}, map[string][]s.Config{}
+	for _, config := range from.Configs {
+		key := memberKey(config)
+		if len(after[key]) == 0 {
+			moved := config.Id + "/" + config.Labels.String()
+			removed[moved] = append(removed[moved], config)
+			continue
+		}
+		next := after[key][0]
+		after[key] = after[key][1:]
+		if member := diffMember(config, next); !member.Entries.empty() {
+			diff.Changed = append(diff.Changed, member)
+		}
+	}
+	for _, configs := range after {
+		for _, config := range configs {
+			moved := config.Id + "/" + config.Labels.String()
+			added[moved] = append(added[moved], config)
+		}
+	}
+	for moved, configs := range removed {
+		if len(configs) == 1 && len(added[moved]) == 1 {
+			diff.Changed = append(diff.Changed, diffMember(configs[0], added[moved][0]))
+			delete(added, moved)
+			continue
+		}
+		diff.Removed = append(diff.Removed, configs...)
+	}
+	for _, configs := range added {
+		diff.Added = append(diff.Added, configs...)
+	}
+
+	sortMembers(diff.Added)
+	sortMembers(diff.Removed)
+	sort.Slice(diff.Changed, func(i, j int) bool {
+		if diff.Changed[i].Id != diff.Changed[j].Id {
+			return diff.Changed[i].Id < diff.Changed[j].Id
+		}
+		return diff.Changed[i].FromVersion < diff.Changed[j].FromVersion
+	})
+	return diff
+}
+
+// memberKey identifies a member config of a group.
+func memberKey(config s.Config) string {
+	return config.Id + "/" + config.Version + "/" + config.Labels.String()
+}
+
+func diffMember(from s.Config, to s.Config) MemberDiff {
+	return MemberDiff{
+		Id:          from.Id,
+		FromVersion: from.Version,
+		ToVersion:   to.Version,
+		Entries:     diffEntries(from.Entries, to.Entries),
+		Labels:      diffEntries(from.Labels, to.Labels),
+	}
+}
+
+func sortMembers(configs []s.Config) {
+	sort.Slice(configs, func(i, j int) bool { return memberKey(configs[i]) < memberKey(configs[j]) })
+}
+
+// diffVersions resolves the from and to query parameters, which accept the
+// same ranges as the version path segment.
+func diffVersions(req *http.Request, resolve func(version string) (string, error)) (string, string, error) {
+	query := req.URL.Query()
+	if query.Get("from") == "" || query.Get("to") == "" {
+		return "", "", errDiffVersions
+	}
+	from, err := resolve(query.Get("from"))
+	if err != nil {
+		return "", "", err
+	}
+	to, err := resolve(query.Get("to"))
+	if err != nil {
+		return "", "", err
+	}
+	return from, to, nil
+}
+
+// findConfig returns the config of version with exactly labels, the one
+// without labels when labels is empty.
+func (cs *configServer) findConfig(ctx context.Context, id string, version string, labels s.Labels) (*s.Config, error) {
+	configs, err := cs.store.Get(ctx, id, version)
+	if err != nil {
+		return nil, err
+	}
+	for _, config := range configs {
+		if config.Labels.String() == labels.String() {
+			return config, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: %s version %s", s.ErrConfigNotFound, id, version)
+}
+
+// swagger:route GET /config/{id}/diff/ config diffConfig
+// Diff two versions of a config
+//
+// responses:
+//
+//	400: ErrorResponse
+//	404: ErrorResponse
+//	200: ConfigDiffResponse
+func (cs *configServer) diffConfigHandler(w http.ResponseWriter, req *http.Request) {
+	span := tracer.StartSpanFromRequest("diffConfigHandler", cs.tracer, req)
+	defer span.Finish()
+
+	span.LogFields(
+		tracer.LogString("handler", fmt.Sprintf("handling config diff at %s\n", req.URL.Path)),
+	)
+
+	ctx := tracer.ContextWithSpan(context.Background(), span)
+	id := mux.Vars(req)["id"]
+
+	labels, err := s.ParseLabels(req.URL.Query().Get("labels"))
+	if err != nil {
+		writeError(w, req, span, err)
+		return
+	}
+	from, to, err := diffVersions(req, func(version string) (string, error) {
+		return cs.store.ResolveConfigVersion(ctx, id, version)
+	})
+	if err != nil {
+		writeError(w, req, span, err)
+		return
+	}
+
+	before, err := cs.findConfig(ctx, id, from, labels)
+	if err != nil {
+		writeError(w, req, span, err)
+		return
+	}
+	after, err := cs.findConfig(ctx, id, to, labels)
+	if err != nil {
+		writeError(w, req, span, err)
+		return
+	}
+	renderJSON(ctx, w, diffConfigs(before, after))
+}
+
+// swagger:route GET /group/{id}/diff/ group diffGroup
+// Diff two versions of a group, including its member configs
+//
+// responses:
+//
+//	400: ErrorResponse
+//	404: ErrorResponse
+//	200: GroupDiffResponse
+func (cs *configServer) diffGroupHandler(w http.ResponseWriter, req *http.Request) {
+	span := tracer.StartSpanFromRequest("diffGroupHandler", cs.tracer, req)
+	defer span.Finish()
+
+	span.LogFields(
+		tracer.LogString("handler", fmt.Sprintf("handling group diff at %s\n", req.URL.Path)),
+	)
+
+	ctx := tracer.ContextWithSpan(context.Background(), span)
+	id := mux.Vars(req)["id"]
+
+	from, to, err := diffVersions(req, func(version string) (string, error) {
+		return cs.store.ResolveGroupVersion(ctx, id, version)
+	})
+	if err != nil {
+		writeError(w, req, span, err)
+		return
+	}
+
+	before, err := cs.store.GetOneGroup(ctx, id, from)
+	if err != nil {
+		writeError(w, req, span, err)
+		return
+	}
+	after, err := cs.store.GetOneGroup(ctx, id, to)
+	if err != nil {
+		writeError(w, req, span, err)
+		return
+	}
+	if _, err := cs.resolveGroups(ctx, []*s.Group{before, after}, true); err != nil {
+		writeProblem(w, req, span, http.StatusInternalServerError, err)
+		return
+	}
+	renderJSON(ctx, w, diffGroups(before, after))
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"encoding/json"
+	s "example.com/mod/store"
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestDiffGroupsKeepsVersionsApart(t *testing.T) {
+	config := func(id string, version string, value string) s.Config {
+		return s.Config{Id: id, Version: version, Entries: map[string]string{"a": value}}
+	}
+	from := &s.Group{Id: "g", Version: "1.0.0", Configs: []s.Config{
+		config("c1", "1.0.0", "1"),
+		config("c1", "2.0.0", "2"),
+		config("c2", "1.0.0", "1"),
+	}}
+	to := &s.Group{Id: "g", Version: "2.0.0", Configs: []s.Config{
+		config("c1", "2.0.0", "3"),
+		config("c1", "3.0.0", "3"),
+		config("c1", "4.0.0", "4"),
+		config("c2", "1.1.0", "1"),
+	}}
+
+	diff := diffGroups(from, to)
+	if len(diff.Removed) != 1 || memberKey(diff.Removed[0]) != "c1/1.0.0/" {
+		t.Errorf("removed = %v", diff.Removed)
+	}
+	// c1 1.0.0 could have become 3.0.0 or 4.0.0, so it is not a change.
+	if len(diff.Added) != 2 || memberKey(diff.Added[0]) != "c1/3.0.0/" || memberKey(diff.Added[1]) != "c1/4.0.0/" {
+		t.Errorf("added = %v", diff.Added)
+	}
+	if len(diff.Changed) != 2 {
+		t.Fatalf("changed = %v", diff.Changed)
+	}
+	// c1 2.0.0 is in both, with other entries.
+	if got := diff.Changed[0]; got.Id != "c1" || got.FromVersion != "2.0.0" || got.ToVersion != "2.0.0" ||
+		!reflect.DeepEqual(got.Entries.Changed, map[string]EntryChange{"a": {From: "2", To: "3"}}) {
+		t.Errorf("changed c1 = %+v", got)
+	}
+	// c2 is the only version on both sides.
+	if got := diff.Changed[1]; got.Id != "c2" || got.FromVersion != "1.0.0" || got.ToVersion != "1.1.0" || !got.Entries.empty() {
+		t.Errorf("changed c2 = %+v", got)
+	}
+}
+
+func TestDiffHandlers(t *testing.T) {
+	_, ts := newTestServer(t)
+	config := createConfig(t, ts, `{"version":"1.0.0","entries":{"a":"1","b":"2"}}`)
+	resp, data := do(t, http.MethodPost, ts.URL+"/config/"+config.Id+"/", `{"version":"1.1.0","entries":{"a":"1","b":"3","c":"4"}}`, nil)
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("POST /config/{id}/ returned %d: %s", resp.StatusCode, data)
+	}
+
+	resp, data = do(t, http.MethodGet, ts.URL+"/config/"+config.Id+"/diff/?from=1.0.0&to=latest", "", nil)
+	configDiff := ConfigDiff{}
+	if err := json.Unmarshal([]byte(data), &configDiff); resp.StatusCode != http.StatusOK || err != nil {
+		t.Fatalf("config diff returned %d: %s", resp.StatusCode, data)
+	}
+	if configDiff.To != "1.1.0" || !reflect.DeepEqual(configDiff.Entries.Added, map[string]string{"c": "4"}) ||
+		!reflect.DeepEqual(configDiff.Entries.Changed, map[string]EntryChange{"b": {From: "2", To: "3"}}) {
+		t.Errorf("config diff = %+v", configDiff)
+	}
+	if resp, _ = do(t, http.MethodGet, ts.URL+"/config/"+config.Id+"/diff/?from=1.0.0", "", nil); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("config diff without to returned %d", resp.StatusCode)
+	}
+
+	v1 := `{"id":"` + config.Id + `","version":"1.0.0","entries":{"a":"1","b":"2"}}`
+	v2 := `{"id":"` + config.Id + `","version":"1.1.0","entries":{"a":"1","b":"3","c":"4"}}`
+	resp, data = do(t, http.MethodPost, ts.URL+"/group/", `{"version":"1.0.0","configs":[`+v1+`]}`, nil)
+	group := &s.Group{}
+	if err := json.Unmarshal([]byte(data), group); resp.StatusCode != http.StatusCreated || err != nil {
+		t.Fatalf("POST /group/ returned %d: %s", resp.StatusCode, data)
+	}
+	if resp, data = do(t, http.MethodPost, ts.URL+"/group/"+group.Id+"/", `{"version":"2.0.0","configs":[`+v1+`,`+v2+`]}`, nil); resp.StatusCode != http.StatusCreated {
+		t.Fatalf("POST /group/{id}/ returned %d: %s", resp.StatusCode, data)
+	}
+
+	resp, data = do(t, http.MethodGet, ts.URL+"/group/"+group.Id+"/diff/?from=1.0.0&to=2.0.0", "", nil)
+	groupDiff := GroupDiff{}
+	if err := json.Unmarshal([]byte(data), &groupDiff); resp.StatusCode != http.StatusOK || err != nil {
+		t.Fatalf("group diff returned %d: %s", resp.StatusCode, data)
+	}
+	if len(groupDiff.Added) != 1 || groupDiff.Added[0].Version != "1.1.0" || len(groupDiff.Removed) != 0 || len(groupDiff.Changed) != 0 {
+		t.Errorf("group diff = %+v", groupDiff)
+	}
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	s "example.com/mod/store"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	configKind = "config"
+	groupKind  = "group"
+
+	eventCreated = "created"
+	eventUpdated = "updated"
+	eventDeleted = "deleted"
+
+	// eventBuffer is how many events a slow subscriber may fall behind
+	// before it starts missing them.
+	eventBuffer = 64
+
+	keepAliveInterval = 15 * time.Second
+)
+
+// Event describes one change of a config or group.
+type Event struct {
+	// Sequence number of the event, sent as the SSE id
+	Seq uint64 `json:"seq"`
+
+	// config or group
+	Kind string `json:"kind"`
+
+	// created, updated or deleted
+	Type string `json:"type"`
+
+	Id      string `json:"id"`
+	Version string `json:"version,omitempty"`
+	Labels  string `json:"labels,omitempty"`
+
+	// Resource after the change, empty for deletes
+	Config *s.Config `json:"config,omitempty"`
+	Group  *s.Group  `json:"group,omitempty"`
+}
+
+// eventBroker fans events out to every subscriber of this instance.
+type eventBroker struct {
+	mu   sync.Mutex
+	seq  uint64
+	subs map[chan Event]struct{}
+}
+
+func newEventBroker() *eventBroker {
+	return &eventBroker{subs: map[chan Event]struct{}{}}
+}
+
+func (b *eventBroker) subscribe() (<-chan Event, func()) {
+	ch := make(chan Event, eventBuffer)
+
+	b.mu.Lock()
+	b.subs[ch] = struct{}{}
+	b.mu.Unlock()
+
+	return ch, func() {
+		b.mu.Lock()
+		delete(b.subs, ch)
+		b.mu.Unlock()
+	}
+}
+
+func (b *eventBroker) publish(e Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.seq++
+	e.Seq = b.seq
+	for ch := range b.subs {
+		select {
+		case ch <- e:
+		default:
+			log.Printf("dropping event %d for slow subscriber", e.Seq)
+		}
+	}
+}
+
+func (b *eventBroker) configEvent(typ string, config *s.Config) {
+	e := Event{Kind: configKind, Type: typ, Id: config.Id, Version: config.Version, Labels: config.Labels.String()}
+	if typ != eventDeleted {
+		e.Config = config
+	}
+	b.publish(e)
+}
+
+func (b *eventBroker) groupEvent(typ string, group *s.Group) {
+	e := Event{Kind: groupKind, Type: typ, Id: group.Id, Version: group.Version, Labels: group.Labels.String()}
+	if typ != eventDeleted {
+		e.Group = group
+	}
+	b.publish(e)
+}
+
+// eventFilter selects events by the kind, id, version and label query
+// parameters of an events request. Empty fields match everything.
+type eventFilter struct {
+	kind    string
+	id      string
+	version string
+	label   string
+}
+
+func (f eventFilter) match(e Event) bool {
+	return (f.kind == "" || f.kind == e.Kind) &&
+		(f.id == "" || f.id == e.Id) &&
+		(f.version == "" || f.version == e.Version) &&
+		(f.label == "" || f.label == e.Labels)
+}
+
+// swagger:route GET /events/ events streamEvents
+// Stream config and group changes as Server-Sent Events
+//
+// responses:
+//
+//	500: ErrorResponse
+//	200: NoContentResponse
+func (cs *configServer) eventsHandler(w http.ResponseWriter, req *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeProblem(w, req, nil, http.StatusInternalServerError, errors.New("streaming unsupported"))
+		return
+	}
+
+	query := req.URL.Query()
+	filter := eventFilter{
+		kind:    query.Get("kind"),
+		id:      query.Get("id"),
+		version: query.Get("version"),
+		label:   query.Get("label"),
+	}
+
+	events, unsubscribe := cs.events.subscribe()
+	defer unsubscribe()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(keepAliveInterval)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case e := <-events:
+			if !filter.match(e) {
+				continue
+			}
+			data, err := json.Marshal(e)
+			if err != nil {
+				log.Println(err)
+				continue
+			}
+			fmt.Fprintf(w, "id: %d\nevent: %s.%s\ndata: %s\n\n", e.Seq, e.Kind, e.Type, data)
+			flusher.Flush()
+		case <-keepAlive.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+			flusher.Flush()
+		case <-req.Context().Done():
+			return
+		}
+	}
+}
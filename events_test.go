@@ -0,0 +1,59 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEventsStream(t *testing.T) {
+	_, ts := newTestServer(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, ts.URL+"/events/?kind=config&version=1.0.0", nil)
+	if err != nil {
+		t.Fatalf("NewRequest failed: %v", err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("GET /events/ failed: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("GET /events/ returned %d, %s", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+
+	// The group and the other version are filtered out.
+	do(t, http.MethodPost, ts.URL+"/group/", `{"version":"1.0.0"}`, nil)
+	createConfig(t, ts, `{"version":"2.0.0","entries":{"a":"1"}}`)
+	config := createConfig(t, ts, `{"version":"1.0.0","entries":{"a":"1"}}`)
+	do(t, http.MethodDelete, ts.URL+"/config/"+config.Id+"/1.0.0/", "", nil)
+
+	scanner := bufio.NewScanner(resp.Body)
+	for _, want := range []string{"config.created", "config.deleted"} {
+		name, data := "", ""
+		for data == "" && scanner.Scan() {
+			line := scanner.Text()
+			switch {
+			case strings.HasPrefix(line, "event: "):
+				name = strings.TrimPrefix(line, "event: ")
+			case strings.HasPrefix(line, "data: "):
+				data = strings.TrimPrefix(line, "data: ")
+			}
+		}
+		if data == "" {
+			t.Fatalf("stream ended before %s: %v", want, scanner.Err())
+		}
+		e := Event{}
+		if err := json.Unmarshal([]byte(data), &e); err != nil {
+			t.Fatalf("decoding %s failed: %v", data, err)
+		}
+		if name != want || e.Id != config.Id || e.Version != "1.0.0" {
+			t.Errorf("got event %s %+v, want %s of %s", name, e, want, config.Id)
+		}
+	}
+}
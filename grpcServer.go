@@ -0,0 +1,540 @@
+package main
+
+import (
+	"context"
+	"errors"
+	pb "example.com/mod/proto"
+	s "example.com/mod/store"
+	tracer "example.com/mod/tracer"
+	"fmt"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"net/http"
+	"time"
+)
+
+// grpcConfigServer serves the same store and event stream as the REST
+// handlers, so both APIs see each others changes.
+type grpcConfigServer struct {
+	pb.UnimplementedConfigServiceServer
+	cs *configServer
+}
+
+func newGRPCServer(cs *configServer) *grpc.Server {
+	srv := grpc.NewServer()
+	pb.RegisterConfigServiceServer(srv, &grpcConfigServer{cs: cs})
+	return srv
+}
+
+func (g *grpcConfigServer) CreateConfig(ctx context.Context, req *pb.CreateConfigRequest) (*pb.Config, error) {
+	span := tracer.StartSpanFromContext(ctx, "grpcCreateConfig")
+	defer span.Finish()
+
+	if req.GetConfig() == nil {
+		return nil, status.Error(codes.InvalidArgument, "config is required")
+	}
+	if err := s.Labels(req.GetConfig().GetLabels()).Validate(); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	ctx = changeFromMetadata(tracer.ContextWithSpan(ctx, span))
+	var config *s.Config
+	var err error
+	if req.GetConfig().GetId() != "" {
+		config, err = g.cs.store.NewConfigVersion(ctx, configFromProto(req.GetConfig()))
+	} else {
+		config, err = g.cs.store.Config(ctx, configFromProto(req.GetConfig()))
+	}
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	g.cs.events.configEvent(eventCreated, config)
+	return configToProto(config), nil
+}
+
+func (g *grpcConfigServer) GetConfig(ctx context.Context, req *pb.GetConfigRequest) (*pb.ConfigList, error) {
+	span := tracer.StartSpanFromContext(ctx, "grpcGetConfig")
+	defer span.Finish()
+
+	ctx = tracer.ContextWithSpan(ctx, span)
+	version, err := g.cs.store.ResolveConfigVersion(ctx, req.GetId(), req.GetVersion())
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	var configs []*s.Config
+	if req.GetLabels() != "" {
+		configs, err = g.cs.store.GetConfigsByLabels(ctx, req.GetId(), version, req.GetLabels())
+	} else {
+		configs, err = g.cs.store.Get(ctx, req.GetId(), version)
+	}
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	if len(configs) == 0 {
+		return nil, status.Error(codes.NotFound, s.ErrConfigNotFound.Error())
+	}
+	return configsToProto(configs), nil
+}
+
+func (g *grpcConfigServer) ListConfigs(ctx context.Context, req *pb.ListConfigsRequest) (*pb.ConfigList, error) {
+	span := tracer.StartSpanFromContext(ctx, "grpcListConfigs")
+	defer span.Finish()
+
+	selector, err := s.ParseSelector(req.GetSelector())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	labels, err := s.ParseLabels(req.GetLabels())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	opts, err := pageRequest(req.GetPageSize(), req.GetPageToken(), req.GetSort(), req.GetUpdatedSince())
+	if err != nil {
+		return nil, err
+	}
+	opts.Selector, opts.Labels = selector, labels
+
+	configs, next, err := g.cs.store.ListConfigs(tracer.ContextWithSpan(ctx, span), opts)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	list := configsToProto(configs)
+	list.NextPageToken = next
+	return list, nil
+}
+
+func (g *grpcConfigServer) DeleteConfig(ctx context.Context, req *pb.DeleteConfigRequest) (*pb.DeleteResponse, error) {
+	span := tracer.StartSpanFromContext(ctx, "grpcDeleteConfig")
+	defer span.Finish()
+
+	labels, err := s.ParseLabels(req.GetLabels())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	ctx = changeFromMetadata(tracer.ContextWithSpan(ctx, span))
+	var msg map[string]string
+	var groups []*s.Group
+	if req.GetLabels() != "" {
+		msg, groups, err = g.cs.store.DeleteByLabel(ctx, req.GetId(), req.GetVersion(), req.GetLabels(), req.GetCascade())
+	} else {
+		msg, groups, err = g.cs.store.Delete(ctx, req.GetId(), req.GetVersion(), req.GetCascade())
+	}
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	g.cs.events.configEvent(eventDeleted, &s.Config{Id: req.GetId(), Version: req.GetVersion(), Labels: labels})
+	for _, group := range groups {
+		g.cs.events.groupEvent(eventUpdated, group)
+	}
+	return &pb.DeleteResponse{Deleted: msg["Deleted"]}, nil
+}
+
+func (g *grpcConfigServer) ListConfigVersions(ctx context.Context, req *pb.VersionsRequest) (*pb.VersionList, error) {
+	span := tracer.StartSpanFromContext(ctx, "grpcListConfigVersions")
+	defer span.Finish()
+
+	versions, err := g.cs.store.ConfigVersions(tracer.ContextWithSpan(ctx, span), req.GetId())
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	if len(versions) == 0 {
+		return nil, status.Error(codes.NotFound, s.ErrConfigNotFound.Error())
+	}
+	return &pb.VersionList{Versions: versions}, nil
+}
+
+func (g *grpcConfigServer) CreateGroup(ctx context.Context, req *pb.CreateGroupRequest) (*pb.Group, error) {
+	span := tracer.StartSpanFromContext(ctx, "grpcCreateGroup")
+	defer span.Finish()
+
+	if req.GetGroup() == nil {
+		return nil, status.Error(codes.InvalidArgument, "group is required")
+	}
+	if err := s.Labels(req.GetGroup().GetLabels()).Validate(); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	group := groupFromProto(req.GetGroup())
+	if err := validateMode(group); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	ctx = changeFromMetadata(tracer.ContextWithSpan(ctx, span))
+	if _, err := g.cs.resolveMembers(ctx, group.Refs); err != nil {
+		return nil, grpcError(err)
+	}
+	var err error
+	if group.Id != "" {
+		group, err = g.cs.store.NewGroupVersion(ctx, group)
+	} else {
+		group, err = g.cs.store.PostGroup(ctx, group)
+	}
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	g.cs.events.groupEvent(eventCreated, group)
+	return groupToProto(group), nil
+}
+
+func (g *grpcConfigServer) GetGroup(ctx context.Context, req *pb.GetGroupRequest) (*pb.GroupList, error) {
+	span := tracer.StartSpanFromContext(ctx, "grpcGetGroup")
+	defer span.Finish()
+
+	selector, err := s.ParseSelector(req.GetSelector())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	ctx = tracer.ContextWithSpan(ctx, span)
+	version := req.GetVersion()
+	if version != "" {
+		version, err = g.cs.store.ResolveGroupVersion(ctx, req.GetId(), version)
+		if err != nil {
+			return nil, grpcError(err)
+		}
+	}
+	groups, err := g.cs.store.GetGroupsBySelector(ctx, req.GetId(), version, selector)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	if len(groups) == 0 {
+		return nil, status.Error(codes.NotFound, s.ErrGroupNotFound.Error())
+	}
+	if _, err := g.cs.resolveGroups(ctx, groups, req.GetExpand()); err != nil {
+		return nil, grpcError(err)
+	}
+	return groupsToProto(groups), nil
+}
+
+func (g *grpcConfigServer) ListGroups(ctx context.Context, req *pb.ListGroupsRequest) (*pb.GroupList, error) {
+	span := tracer.StartSpanFromContext(ctx, "grpcListGroups")
+	defer span.Finish()
+
+	selector, err := s.ParseSelector(req.GetSelector())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	opts, err := pageRequest(req.GetPageSize(), req.GetPageToken(), req.GetSort(), req.GetUpdatedSince())
+	if err != nil {
+		return nil, err
+	}
+	opts.Selector = selector
+
+	ctx = tracer.ContextWithSpan(ctx, span)
+	groups, next, err := g.cs.store.ListGroups(ctx, opts)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	if _, err := g.cs.resolveGroups(ctx, groups, req.GetExpand()); err != nil {
+		return nil, grpcError(err)
+	}
+	list := groupsToProto(groups)
+	list.NextPageToken = next
+	return list, nil
+}
+
+func (g *grpcConfigServer) DeleteGroup(ctx context.Context, req *pb.DeleteGroupRequest) (*pb.DeleteResponse, error) {
+	span := tracer.StartSpanFromContext(ctx, "grpcDeleteGroup")
+	defer span.Finish()
+
+	labels, err := s.ParseLabels(req.GetLabels())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if len(labels) > 0 && req.GetVersion() == "" {
+		return nil, status.Error(codes.InvalidArgument, "labels require a version")
+	}
+	ctx = tracer.ContextWithSpan(ctx, span)
+	var msg map[string]string
+	if len(labels) > 0 {
+		msg, err = g.cs.store.DeleteGroupByLabels(ctx, req.GetId(), req.GetVersion(), req.GetLabels())
+	} else if req.GetVersion() != "" {
+		msg, err = g.cs.store.DeleteGroup(ctx, req.GetId(), req.GetVersion())
+	} else {
+		msg, err = g.cs.store.DeleteGroupId(ctx, req.GetId())
+	}
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	g.cs.events.groupEvent(eventDeleted, &s.Group{Id: req.GetId(), Version: req.GetVersion(), Labels: labels})
+	return &pb.DeleteResponse{Deleted: msg["Deleted"]}, nil
+}
+
+func (g *grpcConfigServer) ListGroupVersions(ctx context.Context, req *pb.VersionsRequest) (*pb.VersionList, error) {
+	span := tracer.StartSpanFromContext(ctx, "grpcListGroupVersions")
+	defer span.Finish()
+
+	versions, err := g.cs.store.GroupVersions(tracer.ContextWithSpan(ctx, span), req.GetId())
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	if len(versions) == 0 {
+		return nil, status.Error(codes.NotFound, s.ErrGroupNotFound.Error())
+	}
+	return &pb.VersionList{Versions: versions}, nil
+}
+
+func (g *grpcConfigServer) AddConfigToGroup(ctx context.Context, req *pb.GroupConfigRequest) (*pb.Group, error) {
+	span := tracer.StartSpanFromContext(ctx, "grpcAddConfigToGroup")
+	defer span.Finish()
+
+	ctx = changeFromMetadata(tracer.ContextWithSpan(ctx, span))
+	var config *s.Config
+	var err error
+	if req.GetConfigVersion() != "" {
+		var version string
+		version, err = g.cs.store.ResolveConfigVersion(ctx, req.GetConfigId(), req.GetConfigVersion())
+		if err != nil {
+			return nil, grpcError(err)
+		}
+		config, err = g.cs.store.GetOneConfig(ctx, req.GetConfigId(), version)
+	} else {
+		config, err = g.cs.store.GetOneConfig2(ctx, req.GetConfigId())
+	}
+	if err != nil {
+		return nil, grpcError(err)
+	}
+
+	version := req.GetConfigVersion()
+	if version == "" {
+		version = config.Version
+	}
+	group, err := g.cs.updateGroup(ctx, ifIndex(req), g.loadGroup(ctx, req), func(group *s.Group) error {
+		addGroupMember(group, config, version)
+		return nil
+	})
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	g.cs.events.groupEvent(eventUpdated, group)
+	return groupToProto(group), nil
+}
+
+func (g *grpcConfigServer) RemoveConfigFromGroup(ctx context.Context, req *pb.GroupConfigRequest) (*pb.Group, error) {
+	span := tracer.StartSpanFromContext(ctx, "grpcRemoveConfigFromGroup")
+	defer span.Finish()
+
+	ctx = changeFromMetadata(tracer.ContextWithSpan(ctx, span))
+	group, err := g.cs.updateGroup(ctx, ifIndex(req), g.loadGroup(ctx, req), func(group *s.Group) error {
+		return removeConfigFromGroup(group, req.GetConfigId())
+	})
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	g.cs.events.groupEvent(eventUpdated, group)
+	return groupToProto(group), nil
+}
+
+// Watch streams the events of this instance matching the request until
+// the client goes away.
+func (g *grpcConfigServer) Watch(req *pb.WatchRequest, stream pb.ConfigService_WatchServer) error {
+	filter := eventFilter{
+		kind:    req.GetKind(),
+		id:      req.GetId(),
+		version: req.GetVersion(),
+		label:   req.GetLabel(),
+	}
+
+	events, unsubscribe := g.cs.events.subscribe()
+	defer unsubscribe()
+
+	for {
+		select {
+		case e := <-events:
+			if !filter.match(e) {
+				continue
+			}
+			if err := stream.Send(eventToProto(e)); err != nil {
+				return err
+			}
+		case <-stream.Context().Done():
+			return nil
+		}
+	}
+}
+
+// loadGroup picks the group lookup like the REST routes do: by version
+// when one is given, otherwise by id only.
+func (g *grpcConfigServer) loadGroup(ctx context.Context, req *pb.GroupConfigRequest) func() (*s.Group, error) {
+	return func() (*s.Group, error) {
+		if req.GetGroupVersion() != "" {
+			return g.cs.store.GetOneGroup(ctx, req.GetGroupId(), req.GetGroupVersion())
+		}
+		return g.cs.store.GetOneGroup2(ctx, req.GetGroupId())
+	}
+}
+
+// ifIndex is the precondition of the optional if_index of a request, it
+// compares the group's own modify index as returned in Group.index.
+func ifIndex(req *pb.GroupConfigRequest) func(*s.Group) error {
+	if req.GetIfIndex() == 0 {
+		return nil
+	}
+	return func(group *s.Group) error {
+		if group.Index != req.GetIfIndex() {
+			return errPreconditionFailed
+		}
+		return nil
+	}
+}
+
+// pageRequest is pageQuery for the list RPCs.
+func pageRequest(size int32, token string, sort string, updatedSince string) (s.PageOptions, error) {
+	opts := s.PageOptions{Limit: defaultPageLimit, Cursor: token, Sort: sort}
+	if size < 0 || size > maxPageLimit {
+		err := fmt.Errorf("%w %d, expect 1 to %d", errInvalidLimit, size, maxPageLimit)
+		return opts, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if size > 0 {
+		opts.Limit = int(size)
+	}
+	if updatedSince != "" {
+		since, err := time.Parse(time.RFC3339, updatedSince)
+		if err != nil {
+			err := fmt.Errorf("%w %q, expect an RFC 3339 time", errInvalidTime, updatedSince)
+			return opts, status.Error(codes.InvalidArgument, err.Error())
+		}
+		opts.UpdatedSince = since
+	}
+	return opts, nil
+}
+
+// changeFromMetadata is withChange for the write RPCs, reading the
+// x-author and x-change-description metadata.
+func changeFromMetadata(ctx context.Context) context.Context {
+	md, _ := metadata.FromIncomingContext(ctx)
+	first := func(key string) string {
+		if values := md.Get(key); len(values) > 0 {
+			return values[0]
+		}
+		return ""
+	}
+	return s.WithChange(ctx, s.Change{
+		Author:      first(authorHeader),
+		Description: first(changeDescriptionHeader),
+	})
+}
+
+func grpcError(err error) error {
+	switch {
+	case errors.Is(err, errConfigNotInGroup), errors.Is(err, s.ErrVersionNotFound):
+		return status.Error(codes.NotFound, err.Error())
+	case errors.Is(err, s.ErrNotFound):
+		return status.Error(codes.NotFound, err.Error())
+	case errors.Is(err, s.ErrVersionExists):
+		return status.Error(codes.AlreadyExists, err.Error())
+	case errors.Is(err, s.ErrConflict):
+		return status.Error(codes.Aborted, err.Error())
+	case errors.Is(err, s.ErrReferenced), errors.Is(err, s.ErrCascadeTooLarge):
+		return status.Error(codes.FailedPrecondition, err.Error())
+	case errors.Is(err, errPreconditionFailed):
+		return status.Error(codes.FailedPrecondition, err.Error())
+	case errors.Is(err, context.Canceled):
+		return status.Error(codes.Canceled, err.Error())
+	case errors.Is(err, context.DeadlineExceeded):
+		return status.Error(codes.DeadlineExceeded, err.Error())
+	}
+	// Everything else is told apart like for REST responses: client errors
+	// are invalid arguments, unknown and store errors are internal.
+	switch httpStatus := errorStatus(err); {
+	case httpStatus == http.StatusNotImplemented:
+		return status.Error(codes.Unimplemented, err.Error())
+	case httpStatus < http.StatusInternalServerError:
+		return status.Error(codes.InvalidArgument, err.Error())
+	default:
+		return status.Error(codes.Internal, err.Error())
+	}
+}
+
+func configFromProto(c *pb.Config) *s.Config {
+	return &s.Config{
+		Id:      c.GetId(),
+		Entries: c.GetEntries(),
+		Labels:  c.GetLabels(),
+		Version: c.GetVersion(),
+	}
+}
+
+func configToProto(c *s.Config) *pb.Config {
+	return &pb.Config{
+		Id:      c.Id,
+		Entries: c.Entries,
+		Labels:  c.Labels,
+		Version: c.Version,
+		Index:   c.Index,
+		Audit:   auditToProto(c.Audit),
+	}
+}
+
+func configsToProto(configs []*s.Config) *pb.ConfigList {
+	list := &pb.ConfigList{}
+	for _, c := range configs {
+		list.Configs = append(list.Configs, configToProto(c))
+	}
+	return list
+}
+
+func groupFromProto(g *pb.Group) *s.Group {
+	group := &s.Group{Id: g.GetId(), Version: g.GetVersion(), Labels: g.GetLabels(), Mode: g.GetMode()}
+	for _, c := range g.GetConfigs() {
+		group.Configs = append(group.Configs, *configFromProto(c))
+	}
+	for _, r := range g.GetRefs() {
+		group.Refs = append(group.Refs, s.ConfigRef{Id: r.GetId(), Version: r.GetVersion(), Labels: r.GetLabels()})
+	}
+	return group
+}
+
+func groupToProto(g *s.Group) *pb.Group {
+	group := &pb.Group{Id: g.Id, Version: g.Version, Index: g.Index, Labels: g.Labels, Mode: g.Mode}
+	for i := range g.Configs {
+		group.Configs = append(group.Configs, configToProto(&g.Configs[i]))
+	}
+	group.Refs = refsToProto(g.Refs)
+	group.Dangling = refsToProto(g.Dangling)
+	group.Audit = auditToProto(g.Audit)
+	return group
+}
+
+func auditToProto(a s.Audit) *pb.Audit {
+	audit := &pb.Audit{CreatedBy: a.CreatedBy, UpdatedBy: a.UpdatedBy, ChangeDescription: a.ChangeDescription}
+	if a.CreatedAt != nil {
+		audit.CreatedAt = a.CreatedAt.Format(time.RFC3339Nano)
+	}
+	if a.UpdatedAt != nil {
+		audit.UpdatedAt = a.UpdatedAt.Format(time.RFC3339Nano)
+	}
+	return audit
+}
+
+func refsToProto(refs []s.ConfigRef) []*pb.ConfigRef {
+	var list []*pb.ConfigRef
+	for _, r := range refs {
+		list = append(list, &pb.ConfigRef{Id: r.Id, Version: r.Version, Labels: r.Labels})
+	}
+	return list
+}
+
+func groupsToProto(groups []*s.Group) *pb.GroupList {
+	list := &pb.GroupList{}
+	for _, g := range groups {
+		list.Groups = append(list.Groups, groupToProto(g))
+	}
+	return list
+}
+
+func eventToProto(e Event) *pb.Event {
+	event := &pb.Event{
+		Seq:     e.Seq,
+		Kind:    e.Kind,
+		Type:    e.Type,
+		Id:      e.Id,
+		Version: e.Version,
+		Labels:  e.Labels,
+	}
+	if e.Config != nil {
+		event.Config = configToProto(e.Config)
+	}
+	if e.Group != nil {
+		event.Group = groupToProto(e.Group)
+	}
+	return event
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	pb "example.com/mod/proto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"net"
+	"testing"
+)
+
+// newTestClient serves the gRPC API of a test server over an in-memory
+// connection.
+func newTestClient(t *testing.T, cs *configServer) pb.ConfigServiceClient {
+	t.Helper()
+	lis := bufconn.Listen(1 << 20)
+	srv := newGRPCServer(cs)
+	go srv.Serve(lis)
+	t.Cleanup(srv.Stop)
+
+	conn, err := grpc.DialContext(context.Background(), "bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return lis.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return pb.NewConfigServiceClient(conn)
+}
+
+func TestGRPCConfigsAndGroups(t *testing.T) {
+	ctx := context.Background()
+	cs, _ := newTestServer(t)
+	client := newTestClient(t, cs)
+
+	config, err := client.CreateConfig(ctx, &pb.CreateConfigRequest{Config: &pb.Config{Version: "1.0.0", Entries: map[string]string{"a": "1"}}})
+	if err != nil || config.GetId() == "" {
+		t.Fatalf("CreateConfig returned %v, %v", config, err)
+	}
+	if _, err := client.CreateConfig(ctx, &pb.CreateConfigRequest{Config: &pb.Config{Id: config.GetId(), Version: "1.0.0"}}); status.Code(err) != codes.AlreadyExists {
+		t.Errorf("expected AlreadyExists for a taken version, got %v", err)
+	}
+
+	configs, err := client.GetConfig(ctx, &pb.GetConfigRequest{Id: config.GetId(), Version: "latest"})
+	if err != nil || len(configs.GetConfigs()) != 1 || configs.GetConfigs()[0].GetEntries()["a"] != "1" {
+		t.Fatalf("GetConfig returned %v, %v", configs, err)
+	}
+	if _, err := client.GetConfig(ctx, &pb.GetConfigRequest{Id: "missing", Version: "1.0.0"}); status.Code(err) != codes.NotFound {
+		t.Errorf("expected NotFound for a missing config, got %v", err)
+	}
+
+	group, err := client.CreateGroup(ctx, &pb.CreateGroupRequest{Group: &pb.Group{Version: "1.0.0"}})
+	if err != nil {
+		t.Fatalf("CreateGroup failed: %v", err)
+	}
+	group, err = client.AddConfigToGroup(ctx, &pb.GroupConfigRequest{
+		GroupId:       group.GetId(),
+		GroupVersion:  "1.0.0",
+		ConfigId:      config.GetId(),
+		ConfigVersion: "1.0.0",
+	})
+	if err != nil || len(group.GetConfigs()) != 1 {
+		t.Fatalf("AddConfigToGroup returned %v, %v", group, err)
+	}
+
+	// The REST handlers share the store.
+	groups, err := cs.store.GetGroup(ctx, group.GetId(), "1.0.0")
+	if err != nil || len(groups) != 1 || len(groups[0].Configs) != 1 || groups[0].Configs[0].Id != config.GetId() {
+		t.Errorf("store holds %v, %v", groups, err)
+	}
+
+	if _, err := client.DeleteConfig(ctx, &pb.DeleteConfigRequest{Id: config.GetId(), Version: "1.0.0"}); status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("expected FailedPrecondition for a config in a group, got %v", err)
+	}
+	if _, err := client.DeleteGroup(ctx, &pb.DeleteGroupRequest{Id: group.GetId(), Version: "1.0.0"}); err != nil {
+		t.Errorf("DeleteGroup failed: %v", err)
+	}
+	if _, err := client.GetGroup(ctx, &pb.GetGroupRequest{Id: group.GetId(), Version: "1.0.0"}); status.Code(err) != codes.NotFound {
+		t.Errorf("expected NotFound for a deleted group, got %v", err)
+	}
+}
@@ -2,12 +2,24 @@ package main
 
 import (
 	"context"
+	"encoding/binary"
 	"encoding/json"
+	"errors"
 	"example.com/mod/store"
 	tracer "example.com/mod/tracer"
+	"fmt"
 	"github.com/google/uuid"
+	"hash/fnv"
 	"io"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	authorHeader            = "X-Author"
+	changeDescriptionHeader = "X-Change-Description"
 )
 
 func decodeBody(ctx context.Context, r io.Reader) (*store.Config, error) {
@@ -18,6 +30,10 @@ func decodeBody(ctx context.Context, r io.Reader) (*store.Config, error) {
 	dec.DisallowUnknownFields()
 	var c store.Config
 	if err := dec.Decode(&c); err != nil {
+		tracer.LogError(span, err)
+		return nil, badRequest(err)
+	}
+	if err := store.ValidateVersion(c.Version); err != nil {
 		tracer.LogError(span, err)
 		return nil, err
 	}
@@ -33,12 +49,68 @@ func decodeGroup(ctx context.Context, r io.Reader) (*store.Group, error) {
 
 	var g store.Group
 	if err := dec.Decode(&g); err != nil {
+		tracer.LogError(span, err)
+		return nil, badRequest(err)
+	}
+	if err := store.ValidateVersion(g.Version); err != nil {
 		tracer.LogError(span, err)
 		return nil, err
 	}
+	if err := validateMode(&g); err != nil {
+		tracer.LogError(span, err)
+		return nil, err
+	}
+	g.Dangling = nil
 	return &g, nil
 }
 
+// validateMode checks that a group only uses the field of its mode,
+// Configs for copies and Refs for references.
+func validateMode(g *store.Group) error {
+	switch {
+	case g.Mode != "" && !g.IsReference():
+		return badRequest(fmt.Errorf("unknown group mode %q", g.Mode))
+	case g.IsReference() && len(g.Configs) > 0:
+		return badRequest(errors.New("a reference mode group holds refs, not configs"))
+	case !g.IsReference() && len(g.Refs) > 0:
+		return badRequest(fmt.Errorf("refs need mode %q", store.ReferenceMode))
+	}
+	return validateRefs(g.Refs)
+}
+
+func validateRefs(refs []store.ConfigRef) error {
+	for _, ref := range refs {
+		if ref.Id == "" || ref.Version == "" {
+			return badRequest(fmt.Errorf("config reference needs an id and a version: %+v", ref))
+		}
+	}
+	return nil
+}
+
+// groupMembers is the body of a full replace of a group's configs.
+type groupMembers struct {
+	Configs []store.ConfigRef `json:"configs"`
+}
+
+func decodeMembers(ctx context.Context, r io.Reader) (*groupMembers, error) {
+	span := tracer.StartSpanFromContext(ctx, "decodeMembers")
+	defer span.Finish()
+
+	dec := json.NewDecoder(r)
+	dec.DisallowUnknownFields()
+
+	var m groupMembers
+	if err := dec.Decode(&m); err != nil {
+		tracer.LogError(span, err)
+		return nil, badRequest(err)
+	}
+	if err := validateRefs(m.Configs); err != nil {
+		tracer.LogError(span, err)
+		return nil, err
+	}
+	return &m, nil
+}
+
 func renderJSON(ctx context.Context, w http.ResponseWriter, v interface{}) {
 	span := tracer.StartSpanFromContext(ctx, "decodeBody")
 	defer span.Finish()
@@ -46,12 +118,180 @@ func renderJSON(ctx context.Context, w http.ResponseWriter, v interface{}) {
 	js, err := json.Marshal(v)
 	if err != nil {
 		tracer.LogError(span, err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		renderProblem(w, newProblem(span, http.StatusInternalServerError, err))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(js)
+}
+
+// withChange records the X-Author and X-Change-Description headers of req
+// as the author and description of the writes made with ctx.
+func withChange(ctx context.Context, req *http.Request) context.Context {
+	return store.WithChange(ctx, store.Change{
+		Author:      req.Header.Get(authorHeader),
+		Description: req.Header.Get(changeDescriptionHeader),
+	})
+}
+
+// renderCreated responds 201 Created with v and the location it can be
+// read back from.
+func renderCreated(ctx context.Context, w http.ResponseWriter, location string, v interface{}) {
+	span := tracer.StartSpanFromContext(ctx, "renderCreated")
+	defer span.Finish()
+
+	js, err := json.Marshal(v)
+	if err != nil {
+		tracer.LogError(span, err)
+		renderProblem(w, newProblem(span, http.StatusInternalServerError, err))
 		return
 	}
 	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", location)
+	w.WriteHeader(http.StatusCreated)
 	w.Write(js)
 }
+
+// configLocation is the path a config version is read back from.
+func configLocation(config *store.Config) string {
+	return fmt.Sprintf("/config/%s/%s/", config.Id, config.Version)
+}
+
+// groupLocation is the path a group version is read back from.
+func groupLocation(group *store.Group) string {
+	return fmt.Sprintf("/group/%s/%s/", group.Id, group.Version)
+}
+
+// configNotFound is the error for a lookup of a config that found nothing.
+func configNotFound(id string, version string) error {
+	return fmt.Errorf("%w: %s version %s", store.ErrConfigNotFound, id, version)
+}
+
+// groupNotFound is the error for a lookup of a group, in any version if
+// version is empty, that found nothing.
+func groupNotFound(id string, version string) error {
+	if version == "" {
+		return fmt.Errorf("%w: %s", store.ErrGroupNotFound, id)
+	}
+	return fmt.Errorf("%w: %s version %s", store.ErrGroupNotFound, id, version)
+}
+
+// setModifyIndex exposes the store modify index of the returned resource,
+// both raw and as its ETag.
+func setModifyIndex(w http.ResponseWriter, index uint64) {
+	w.Header().Set("X-Modify-Index", strconv.FormatUint(index, 10))
+	w.Header().Set("ETag", etag(index))
+}
+
+// etag builds the entity tag of a response out of the modify indexes of
+// the stored resources it was made of.
+func etag(indexes ...uint64) string {
+	if len(indexes) == 1 {
+		return fmt.Sprintf("\"%d\"", indexes[0])
+	}
+	h := fnv.New64a()
+	for _, index := range indexes {
+		binary.Write(h, binary.BigEndian, index)
+	}
+	return fmt.Sprintf("\"%x\"", h.Sum64())
+}
+
+func configsETag(configs []*store.Config) string {
+	indexes := []uint64{}
+	for _, config := range configs {
+		indexes = append(indexes, config.Index)
+	}
+	return etag(indexes...)
+}
+
+// groupsETag also covers refs, the modify indexes of the configs that
+// reference mode groups resolved to.
+func groupsETag(groups []*store.Group, refs ...uint64) string {
+	indexes := []uint64{}
+	for _, group := range groups {
+		indexes = append(indexes, group.Index)
+	}
+	return etag(append(indexes, refs...)...)
+}
+
+// matchETag reports whether tag is listed in an If-Match or If-None-Match
+// header value. Weak tags compare equal to their strong counterpart.
+func matchETag(header string, tag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// notModified writes 304 when the client already has the current
+// representation according to If-None-Match.
+func notModified(w http.ResponseWriter, req *http.Request, tag string) bool {
+	header := req.Header.Get("If-None-Match")
+	if header == "" || !matchETag(header, tag) {
+		return false
+	}
+	w.Header().Set("ETag", tag)
+	w.WriteHeader(http.StatusNotModified)
+	return true
+}
+
+// preconditionFailed reports whether the request carries an If-Match
+// header that does not match the current tag of the resource.
+func preconditionFailed(req *http.Request, tag string) bool {
+	header := req.Header.Get("If-Match")
+	return header != "" && !matchETag(header, tag)
+}
+
+const (
+	defaultWatchWait = 30 * time.Second
+	maxWatchWait     = 10 * time.Minute
+)
+
+// watchQuery parses the ?watch=true&index=N&wait=30s blocking query
+// parameters. Without an index the request does not block and only returns
+// the index to wait on.
+func watchQuery(req *http.Request) (bool, uint64, time.Duration, error) {
+	query := req.URL.Query()
+	if query.Get("watch") != "true" {
+		return false, 0, 0, nil
+	}
+	if query.Get("index") == "" {
+		return true, 0, 0, nil
+	}
+
+	index, err := strconv.ParseUint(query.Get("index"), 10, 64)
+	if err != nil {
+		return false, 0, 0, badRequest(fmt.Errorf("invalid index: %w", err))
+	}
+	wait := defaultWatchWait
+	if query.Get("wait") != "" {
+		wait, err = time.ParseDuration(query.Get("wait"))
+		if err != nil || wait <= 0 {
+			return false, 0, 0, badRequest(fmt.Errorf("invalid wait %q", query.Get("wait")))
+		}
+	}
+	if wait > maxWatchWait {
+		wait = maxWatchWait
+	}
+	return true, index, wait, nil
+}
+
+// boolQuery reads a boolean query parameter, false when it is missing.
+func boolQuery(req *http.Request, name string) (bool, error) {
+	value := req.URL.Query().Get(name)
+	if value == "" {
+		return false, nil
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, badRequest(fmt.Errorf("invalid %s %q", name, value))
+	}
+	return b, nil
+}
+
 func createId() string {
 	return uuid.New().String()
 }
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	s "example.com/mod/store"
+	tracer "example.com/mod/tracer"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"time"
+)
+
+const (
+	idempotencyKeyHeader = "x-idempotency-key"
+
+	// defaultIdempotencyTTL is how long responses are kept for replay when
+	// IDEMPOTENCY_TTL is not set.
+	defaultIdempotencyTTL = 24 * time.Hour
+
+	// idempotencyLease is how long a request holds its key without
+	// renewing it. The request renews it while it runs, retries meanwhile
+	// answer 409. Once it runs out they take the key over, so a request
+	// that never finished, e.g. because the process crashed, does not
+	// block its key for the whole TTL.
+	idempotencyLease = time.Minute
+
+	// idempotencySweepInterval is how often expired keys are dropped, at
+	// most. Expired keys are never replayed, sweeping only frees the space.
+	idempotencySweepInterval = time.Minute
+)
+
+var (
+	errIdempotencyInProgress = errors.New("a request with this idempotency key is still in progress")
+	errIdempotencyMismatch   = errors.New("idempotency key was already used for a different request")
+)
+
+// idempotencyTTL reads IDEMPOTENCY_TTL, a duration like 30m or 24h.
+func idempotencyTTL() (time.Duration, error) {
+	env := os.Getenv("IDEMPOTENCY_TTL")
+	if env == "" {
+		return defaultIdempotencyTTL, nil
+	}
+	ttl, err := time.ParseDuration(env)
+	if err != nil {
+		return 0, err
+	}
+	if ttl <= 0 {
+		return 0, errors.New("IDEMPOTENCY_TTL must be positive")
+	}
+	return ttl, nil
+}
+
+// idempotencyRecorder passes a response through while keeping a copy of
+// it to store.
+type idempotencyRecorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (r *idempotencyRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *idempotencyRecorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	r.body.Write(b)
+	return r.ResponseWriter.Write(b)
+}
+
+// fingerprint identifies a request, so a key reused for another one can be
+// told apart from a retry.
+func fingerprint(req *http.Request, body []byte) string {
+	h := sha256.New()
+	h.Write([]byte(req.Method + " " + req.URL.RequestURI() + "\n"))
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// idempotent handles the x-idempotency-key header for f. Keys are scoped
+// to the method and path. The first request with a key runs f and its
+// response is stored, retries get that response replayed verbatim until
+// the key expires. The key is echoed in the response. Server errors are
+// not stored, the request may be retried with the same key.
+func (cs *configServer) idempotent(f func(http.ResponseWriter, *http.Request)) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, req *http.Request) {
+		key := req.Header.Get(idempotencyKeyHeader)
+		if key == "" {
+			f(w, req)
+			return
+		}
+
+		span := tracer.StartSpanFromRequest("idempotent", cs.tracer, req)
+		defer span.Finish()
+		ctx := tracer.ContextWithSpan(context.Background(), span)
+
+		body, err := io.ReadAll(req.Body)
+		if err != nil {
+			writeError(w, req, span, err)
+			return
+		}
+		req.Body = io.NopCloser(bytes.NewReader(body))
+
+		w.Header().Set(idempotencyKeyHeader, key)
+		scope := req.Method + " " + req.URL.Path
+		sum := fingerprint(req, body)
+		lease := idempotencyLease
+		if cs.idempotencyTTL < lease {
+			lease = cs.idempotencyTTL
+		}
+		stored, claim, err := cs.store.ReserveIdempotencyKey(ctx, scope, key, sum, time.Now().Add(lease))
+		if errors.Is(err, s.ErrConflict) {
+			writeError(w, req, span, errIdempotencyInProgress)
+			return
+		}
+		if err != nil {
+			tracer.LogError(span, err)
+			writeProblem(w, req, span, http.StatusInternalServerError, err)
+			return
+		}
+		if stored != nil {
+			switch {
+			case stored.Fingerprint != sum:
+				writeError(w, req, span, errIdempotencyMismatch)
+			case stored.Status == 0:
+				writeError(w, req, span, errIdempotencyInProgress)
+			default:
+				if stored.ContentType != "" {
+					w.Header().Set("Content-Type", stored.ContentType)
+				}
+				if stored.Location != "" {
+					w.Header().Set("Location", stored.Location)
+				}
+				w.Header().Set("Idempotent-Replayed", "true")
+				w.WriteHeader(stored.Status)
+				w.Write(stored.Body)
+			}
+			return
+		}
+
+		done := make(chan struct{})
+		claims := make(chan uint64)
+		go func() {
+			claims <- cs.renewIdempotencyKey(ctx, scope, key, claim, sum, lease, done)
+		}()
+		rec := &idempotencyRecorder{ResponseWriter: w}
+		f(rec, req)
+		close(done)
+		claim = <-claims
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+
+		if rec.status >= http.StatusInternalServerError {
+			err = cs.store.ReleaseIdempotencyKey(ctx, scope, key, claim)
+		} else {
+			err = cs.store.CompleteIdempotencyKey(ctx, scope, key, claim, &s.IdempotentResponse{
+				Status:      rec.status,
+				ContentType: rec.Header().Get("Content-Type"),
+				Location:    rec.Header().Get("Location"),
+				Body:        rec.body.Bytes(),
+				Fingerprint: sum,
+				ExpiresAt:   time.Now().Add(cs.idempotencyTTL),
+			})
+		}
+		if errors.Is(err, s.ErrConflict) {
+			// A retry took the key over after the lease ran out, its
+			// response is the one that counts.
+			log.Printf("idempotency key %q of %s was taken over, dropping the response", key, scope)
+		}
+		if err != nil {
+			tracer.LogError(span, err)
+		}
+	}
+}
+
+// renewIdempotencyKey renews the lease of claim every half lease until
+// done is closed and returns the claim as last renewed. It stops at the
+// first failed renewal; completing the claim then only succeeds if no
+// retry took the key over meanwhile.
+func (cs *configServer) renewIdempotencyKey(ctx context.Context, scope string, key string, claim uint64, fingerprint string, lease time.Duration, done <-chan struct{}) uint64 {
+	ticker := time.NewTicker(lease / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return claim
+		case <-ticker.C:
+			renewed, err := cs.store.RenewIdempotencyKey(ctx, scope, key, claim, fingerprint, time.Now().Add(lease))
+			if err != nil {
+				log.Println(err)
+				return claim
+			}
+			claim = renewed
+		}
+	}
+}
+
+// sweepIdempotencyKeys drops expired idempotency keys until ctx is done.
+func (cs *configServer) sweepIdempotencyKeys(ctx context.Context) {
+	interval := idempotencySweepInterval
+	if cs.idempotencyTTL < interval {
+		interval = cs.idempotencyTTL
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := cs.store.ExpireIdempotencyKeys(ctx, time.Now()); err != nil {
+				log.Println(err)
+			}
+		}
+	}
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestIdempotencyLeaseIsRenewed(t *testing.T) {
+	cs, _ := newTestServer(t)
+	// Leases last as long as the TTL when it is shorter.
+	cs.idempotencyTTL = 200 * time.Millisecond
+
+	var calls int32
+	ts := httptest.NewServer(http.HandlerFunc(cs.idempotent(func(w http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		time.Sleep(700 * time.Millisecond)
+		w.WriteHeader(http.StatusCreated)
+	})))
+	defer ts.Close()
+	header := map[string]string{idempotencyKeyHeader: "key-1"}
+
+	first := make(chan int)
+	go func() {
+		resp, _ := do(t, http.MethodPost, ts.URL, "", header)
+		first <- resp.StatusCode
+	}()
+
+	// Past the lease the first request started with, it still runs.
+	time.Sleep(450 * time.Millisecond)
+	if resp, _ := do(t, http.MethodPost, ts.URL, "", header); resp.StatusCode != http.StatusConflict {
+		t.Errorf("retry while the first request runs returned %d", resp.StatusCode)
+	}
+	if status := <-first; status != http.StatusCreated {
+		t.Errorf("first request returned %d", status)
+	}
+	if n := atomic.LoadInt32(&calls); n != 1 {
+		t.Errorf("handler ran %d times, want 1", n)
+	}
+}
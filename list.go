@@ -0,0 +1,133 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	s "example.com/mod/store"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// defaultPageLimit is the page size of list endpoints without limit.
+	defaultPageLimit = 100
+
+	// maxPageLimit bounds the limit query parameter.
+	maxPageLimit = 1000
+
+	nextCursorHeader = "X-Next-Cursor"
+)
+
+var (
+	errInvalidLimit  = errors.New("invalid limit")
+	errInvalidFields = errors.New("invalid fields")
+	errInvalidTime   = errors.New("invalid updatedSince")
+)
+
+// pageQuery reads the limit, cursor, sort and updatedSince query parameters.
+func pageQuery(req *http.Request) (s.PageOptions, error) {
+	query := req.URL.Query()
+	opts := s.PageOptions{
+		Limit:  defaultPageLimit,
+		Cursor: query.Get("cursor"),
+		Sort:   query.Get("sort"),
+	}
+	if value := query.Get("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit <= 0 || limit > maxPageLimit {
+			return opts, fmt.Errorf("%w %q, expect 1 to %d", errInvalidLimit, value, maxPageLimit)
+		}
+		opts.Limit = limit
+	}
+	if value := query.Get("updatedSince"); value != "" {
+		since, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return opts, fmt.Errorf("%w %q, expect an RFC 3339 time", errInvalidTime, value)
+		}
+		opts.UpdatedSince = since
+	}
+	return opts, nil
+}
+
+// setNextPage points the client at the page after this one, both as a
+// Link header and as the bare cursor. The last page has neither.
+func setNextPage(w http.ResponseWriter, req *http.Request, next string) {
+	if next == "" {
+		return
+	}
+	u := *req.URL
+	query := u.Query()
+	query.Set("cursor", next)
+	u.RawQuery = query.Encode()
+	w.Header().Set("Link", fmt.Sprintf("<%s>; rel=\"next\"", u.RequestURI()))
+	w.Header().Set(nextCursorHeader, next)
+}
+
+// fieldsQuery reads the comma separated fields query parameter, the JSON
+// names of the fields of model to return. None means all of them.
+func fieldsQuery(req *http.Request, model interface{}) ([]string, error) {
+	value := req.URL.Query().Get("fields")
+	if value == "" {
+		return nil, nil
+	}
+	known := map[string]bool{}
+	jsonFields(reflect.TypeOf(model), known)
+
+	fields := []string{}
+	for _, field := range strings.Split(value, ",") {
+		field = strings.TrimSpace(field)
+		if !known[field] {
+			return nil, fmt.Errorf("%w: unknown field %q", errInvalidFields, field)
+		}
+		fields = append(fields, field)
+	}
+	return fields, nil
+}
+
+// jsonFields adds the JSON names of the fields of t to known, including
+// the ones of embedded structs which encoding/json inlines.
+func jsonFields(t reflect.Type, known map[string]bool) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "" && field.Anonymous && field.Type.Kind() == reflect.Struct {
+			jsonFields(field.Type, known)
+			continue
+		}
+		if name != "" && name != "-" {
+			known[name] = true
+		}
+	}
+}
+
+// project keeps only fields of every item of items, a slice of models.
+// No fields keeps items as they are.
+func project(items interface{}, fields []string) (interface{}, error) {
+	if len(fields) == 0 {
+		return items, nil
+	}
+	data, err := json.Marshal(items)
+	if err != nil {
+		return nil, err
+	}
+	full := []map[string]json.RawMessage{}
+	if err := json.Unmarshal(data, &full); err != nil {
+		return nil, err
+	}
+
+	projected := make([]map[string]json.RawMessage, 0, len(full))
+	for _, item := range full {
+		kept := map[string]json.RawMessage{}
+		for _, field := range fields {
+			if value, ok := item[field]; ok {
+				kept[field] = value
+			}
+		}
+		projected = append(projected, kept)
+	}
+	return projected, nil
+}
@@ -6,6 +6,7 @@ import (
 	"github.com/go-openapi/runtime/middleware"
 	"github.com/gorilla/mux"
 	"log"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
@@ -16,12 +17,9 @@ import (
 func main() {
 	fmt.Println("Hello world")
 
-	quit := make(chan os.Signal)
+	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, os.Interrupt, syscall.SIGTERM)
 
-	router := mux.NewRouter()
-	router.StrictSlash(true)
-
 	/*st, err := ps.New()
 	if err != nil {
 		log.Fatal(err)
@@ -35,28 +33,109 @@ func main() {
 		log.Fatal(err)
 		return
 	}
-	router.HandleFunc("/config/", CountCreateConfig(server.createConfigHandler)).Methods("POST")
+	router := newRouter(server)
+
+	// start server
+
+	srv := &http.Server{Addr: "0.0.0.0:8000", Handler: router}
+	go func() {
+		log.Println("server starting")
+		if err := srv.ListenAndServe(); err != nil {
+			if err != http.ErrServerClosed {
+				log.Fatal(err)
+			}
+		}
+	}()
+
+	sweepCtx, stopSweep := context.WithCancel(context.Background())
+	defer stopSweep()
+	go server.sweepIdempotencyKeys(sweepCtx)
+
+	grpcAddr := os.Getenv("GRPC_ADDR")
+	if grpcAddr == "" {
+		grpcAddr = "0.0.0.0:9000"
+	}
+	grpcSrv := newGRPCServer(server)
+	go func() {
+		lis, err := net.Listen("tcp", grpcAddr)
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Println("grpc server starting")
+		if err := grpcSrv.Serve(lis); err != nil {
+			log.Fatal(err)
+		}
+	}()
+
+	<-quit
+
+	log.Println("service shutting down ...")
+
+	// gracefully stop server
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if err := srv.Shutdown(ctx); err != nil {
+		log.Fatal(err)
+	}
+	// GracefulStop waits for open Watch streams, cut them off with the
+	// same deadline as the HTTP server.
+	stopped := make(chan struct{})
+	go func() {
+		grpcSrv.GracefulStop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+		grpcSrv.Stop()
+	}
+	if err := server.store.Close(); err != nil {
+		log.Println(err)
+	}
+	log.Println("server stopped")
+}
+
+// newRouter registers the REST routes of server.
+func newRouter(server *configServer) *mux.Router {
+	router := mux.NewRouter()
+	router.StrictSlash(true)
+
+	router.HandleFunc("/config/", CountCreateConfig(server.idempotent(server.createConfigHandler))).Methods("POST")
+	router.HandleFunc("/config/{id}/", CountCreateConfigVersion(server.idempotent(server.createConfigVersionHandler))).Methods("POST")
 	router.HandleFunc("/configs/", CountGetAllConfig(server.getAllHandler)).Methods("GET")
 
 	/*router.HandleFunc("/config/{id}/", server.getConfigHandler).Methods("GET")
 	router.HandleFunc("/config/{id}/", server.delConfigHandler).Methods("DELETE")*/
+	router.HandleFunc("/config/{id}/versions/", CountGetConfigVersions(server.getConfigVersionsHandler)).Methods("GET")
+	router.HandleFunc("/config/{id}/diff/", CountDiffConfig(server.diffConfigHandler)).Methods("GET")
 	router.HandleFunc("/config/{id}/{version}/", CountGetConfig(server.getConfigHandler)).Methods("GET")
 	router.HandleFunc("/config/{id}/{version}/", CountDelConfig(server.delConfigHandler)).Methods("DELETE")
+	router.HandleFunc("/config/{id}/{version}/", CountPatchConfig(server.idempotent(server.patchConfigHandler))).Methods("PATCH")
 	router.HandleFunc("/config/{id}/{version}/{labels}/", CountDelConfigByLabels(server.delConfigByLabelHandler)).Methods("DELETE")
 	router.HandleFunc("/config/{id}/{version}/{labels}/", CountGetConfigByLabels(server.getPostByLabel)).Methods("GET")
 
-	router.HandleFunc("/group/", CountCreateGroup(server.createGroupHandler)).Methods("POST")
+	router.HandleFunc("/group/", CountCreateGroup(server.idempotent(server.createGroupHandler))).Methods("POST")
+	router.HandleFunc("/group/{id}/", CountCreateGroupVersion(server.idempotent(server.createGroupVersionHandler))).Methods("POST")
 	router.HandleFunc("/groups/", CountGetAllGroup(server.getAllGroupsHandler)).Methods("GET")
+	router.HandleFunc("/groups/subscribe/", CountGroupSubscribe(server.groupSubscribeHandler)).Methods("GET")
 	router.HandleFunc("/group/{id}/", CountGetGroupId(server.getGroupHandlerId)).Methods("GET")
 	router.HandleFunc("/group/{id}/", CountDelGroupId(server.delGroupHandlerId)).Methods("DELETE")
+	router.HandleFunc("/group/{id}/versions/", CountGetGroupVersions(server.getGroupVersionsHandler)).Methods("GET")
+	router.HandleFunc("/group/{id}/diff/", CountDiffGroup(server.diffGroupHandler)).Methods("GET")
 	router.HandleFunc("/group/{id}/{version}/", CountGetGroup(server.getGroupHandler)).Methods("GET")
 	router.HandleFunc("/group/{id}/{version}/", CountDelGroup(server.delGroupHandler)).Methods("DELETE")
-	//router.HandleFunc("/group/{id}/{version}/{labels}", CountGetGroupByLabels(server.getGroupsByLabel)).Methods("GET")
+	router.HandleFunc("/group/{id}/{version}/", CountReplaceGroupConfigs(server.replaceGroupConfigsHandler)).Methods("PUT")
 	router.HandleFunc("/group/{groupId}/{g_version}/config/{id}/", CountDelConfigFromGroup(server.delConfigFromGroupHandler)).Methods("DELETE")
 	router.HandleFunc("/group/{groupId}/config/{id}/", CountDelConfigFromGroup2(server.delConfigFromGroupHandler2)).Methods("DELETE")
+	// After the config routes above, which share the number of segments.
+	router.HandleFunc("/group/{id}/{version}/{labels}/", CountGetGroupByLabels(server.getGroupsByLabel)).Methods("GET")
+	router.HandleFunc("/group/{id}/{version}/{labels}/", CountDelGroupByLabels(server.delGroupByLabelHandler)).Methods("DELETE")
 
-	router.HandleFunc("/group/{g_id}/{g_version}/config/{c_id}/{c_version}/", CountAddConfigToGroup(server.addConfigToGroup)).Methods("PUT")
-	router.HandleFunc("/group/{g_id}/config/{c_id}/", CountAddConfigToGroup2(server.addConfigToGroup2)).Methods("PUT")
+	router.HandleFunc("/group/{g_id}/{g_version}/config/{c_id}/{c_version}/", CountAddConfigToGroup(server.idempotent(server.addConfigToGroup))).Methods("PUT")
+	router.HandleFunc("/group/{g_id}/config/{c_id}/", CountAddConfigToGroup2(server.idempotent(server.addConfigToGroup2))).Methods("PUT")
+
+	router.HandleFunc("/events/", CountEvents(server.eventsHandler)).Methods("GET")
 
 	router.HandleFunc("/swagger.yaml", SwaggerHits(server.swaggerHandler)).Methods("GET")
 
@@ -67,28 +146,5 @@ func main() {
 	developerDocumentationHandler := middleware.SwaggerUI(optionsDevelopers, nil)
 	router.Handle("/docs", developerDocumentationHandler)
 
-	// start server
-
-	srv := &http.Server{Addr: "0.0.0.0:8000", Handler: router}
-	go func() {
-		log.Println("server starting")
-		if err := srv.ListenAndServe(); err != nil {
-			if err != http.ErrServerClosed {
-				log.Fatal(err)
-			}
-		}
-	}()
-
-	<-quit
-
-	log.Println("service shutting down ...")
-
-	// gracefully stop server
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
-
-	if err := srv.Shutdown(ctx); err != nil {
-		log.Fatal(err)
-	}
-	log.Println("server stopped")
+	return router
 }
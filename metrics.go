@@ -144,6 +144,66 @@ var (
 			Help: "Total number of del config from group hits.",
 		},
 	)
+	eventsHits = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "events_http_hit_total",
+			Help: "Total number of events stream hits.",
+		},
+	)
+	groupSubscribeHits = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "group_subscribe_http_hit_total",
+			Help: "Total number of group subscription hits.",
+		},
+	)
+	getConfigVersionsHits = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "get_config_versions_http_hit_total",
+			Help: "Total number of get config versions hits.",
+		},
+	)
+	getGroupVersionsHits = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "get_group_versions_http_hit_total",
+			Help: "Total number of get group versions hits.",
+		},
+	)
+	createConfigVersionHits = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "create_config_version_http_hit_total",
+			Help: "Total number of create config version hits.",
+		},
+	)
+	createGroupVersionHits = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "create_group_version_http_hit_total",
+			Help: "Total number of create group version hits.",
+		},
+	)
+	diffConfigHits = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "diff_config_http_hit_total",
+			Help: "Total number of diff config hits.",
+		},
+	)
+	diffGroupHits = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "diff_group_http_hit_total",
+			Help: "Total number of diff group hits.",
+		},
+	)
+	patchConfigHits = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "patch_config_http_hit_total",
+			Help: "Total number of patch config hits.",
+		},
+	)
+	replaceGroupConfigsHits = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "replace_group_configs_http_hit_total",
+			Help: "Total number of replace group configs hits.",
+		},
+	)
 	swaggerHits = prometheus.NewCounter(
 		prometheus.CounterOpts{
 			Name: "swagger_http_hit_total",
@@ -173,6 +233,16 @@ var (
 		addConfigToGroup2Hits,
 		delConfigFromGroupHits,
 		delConfigFromGroup2Hits,
+		eventsHits,
+		groupSubscribeHits,
+		getConfigVersionsHits,
+		getGroupVersionsHits,
+		createConfigVersionHits,
+		createGroupVersionHits,
+		diffConfigHits,
+		diffGroupHits,
+		patchConfigHits,
+		replaceGroupConfigsHits,
 		swaggerHits,
 	}
 
@@ -303,7 +373,7 @@ func CountDelConfigByLabels(f func(http.ResponseWriter, *http.Request)) func(htt
 func CountGetGroupByLabels(f func(w http.ResponseWriter, req *http.Request)) func(http.ResponseWriter, *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
 		httpHits.Inc()
-		getConfigByLabelsHits.Inc()
+		getGroupByLabelsHits.Inc()
 		f(w, r) // original function call
 	}
 }
@@ -311,7 +381,7 @@ func CountGetGroupByLabels(f func(w http.ResponseWriter, req *http.Request)) fun
 func CountDelGroupByLabels(f func(http.ResponseWriter, *http.Request)) func(http.ResponseWriter, *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
 		httpHits.Inc()
-		delConfigByLabelsHits.Inc()
+		delGroupByLabelsHits.Inc()
 		f(w, r) // original function call
 	}
 }
@@ -347,6 +417,76 @@ func CountDelConfigFromGroup2(f func(http.ResponseWriter, *http.Request)) func(h
 		f(w, r) // original function call
 	}
 }
+func CountEvents(f func(http.ResponseWriter, *http.Request)) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		httpHits.Inc()
+		eventsHits.Inc()
+		f(w, r) // original function call
+	}
+}
+func CountGroupSubscribe(f func(http.ResponseWriter, *http.Request)) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		httpHits.Inc()
+		groupSubscribeHits.Inc()
+		f(w, r) // original function call
+	}
+}
+func CountGetConfigVersions(f func(http.ResponseWriter, *http.Request)) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		httpHits.Inc()
+		getConfigVersionsHits.Inc()
+		f(w, r) // original function call
+	}
+}
+func CountGetGroupVersions(f func(http.ResponseWriter, *http.Request)) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		httpHits.Inc()
+		getGroupVersionsHits.Inc()
+		f(w, r) // original function call
+	}
+}
+func CountCreateConfigVersion(f func(http.ResponseWriter, *http.Request)) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		httpHits.Inc()
+		createConfigVersionHits.Inc()
+		f(w, r) // original function call
+	}
+}
+func CountCreateGroupVersion(f func(http.ResponseWriter, *http.Request)) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		httpHits.Inc()
+		createGroupVersionHits.Inc()
+		f(w, r) // original function call
+	}
+}
+func CountDiffConfig(f func(http.ResponseWriter, *http.Request)) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		httpHits.Inc()
+		diffConfigHits.Inc()
+		f(w, r) // original function call
+	}
+}
+func CountDiffGroup(f func(http.ResponseWriter, *http.Request)) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		httpHits.Inc()
+		diffGroupHits.Inc()
+		f(w, r) // original function call
+	}
+}
+func CountPatchConfig(f func(http.ResponseWriter, *http.Request)) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		httpHits.Inc()
+		patchConfigHits.Inc()
+		f(w, r) // original function call
+	}
+}
+func CountReplaceGroupConfigs(f func(http.ResponseWriter, *http.Request)) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		httpHits.Inc()
+		replaceGroupConfigsHits.Inc()
+		f(w, r) // original function call
+	}
+}
 func SwaggerHits(f func(http.ResponseWriter, *http.Request)) func(http.ResponseWriter, *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
 		httpHits.Inc()
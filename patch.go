@@ -0,0 +1,180 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	s "example.com/mod/store"
+	tracer "example.com/mod/tracer"
+	"fmt"
+	jsonpatch "github.com/evanphx/json-patch/v5"
+	"github.com/gorilla/mux"
+	"io"
+	"mime"
+	"net/http"
+)
+
+const (
+	mergePatchType = "application/merge-patch+json"
+	jsonPatchType  = "application/json-patch+json"
+)
+
+// patchDocument is the part of a config a patch applies to. Id and version
+// are not part of it, so a patch touching them fails strict decoding.
+type patchDocument struct {
+	Entries map[string]string `json:"entries"`
+	Labels  s.Labels          `json:"labels"`
+}
+
+// applyPatch applies a merge patch or JSON patch, by mediatype, to the
+// entries and labels of config and returns the result as a new config.
+func applyPatch(ctx context.Context, mediatype string, config *s.Config, patch []byte) (*s.Config, error) {
+	span := tracer.StartSpanFromContext(ctx, "applyPatch")
+	defer span.Finish()
+
+	doc := patchDocument{Entries: config.Entries, Labels: config.Labels}
+	if doc.Entries == nil {
+		doc.Entries = map[string]string{}
+	}
+	if doc.Labels == nil {
+		doc.Labels = s.Labels{}
+	}
+	original, err := json.Marshal(doc)
+	if err != nil {
+		tracer.LogError(span, err)
+		return nil, err
+	}
+
+	var patched []byte
+	switch mediatype {
+	case mergePatchType:
+		patched, err = jsonpatch.MergePatch(original, patch)
+	case jsonPatchType:
+		var ops jsonpatch.Patch
+		ops, err = jsonpatch.DecodePatch(patch)
+		if err == nil {
+			patched, err = ops.Apply(original)
+		}
+	default:
+		err = fmt.Errorf("unsupported patch type %q", mediatype)
+	}
+	if err != nil {
+		tracer.LogError(span, err)
+		return nil, badRequest(err)
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(patched))
+	dec.DisallowUnknownFields()
+	var result patchDocument
+	if err := dec.Decode(&result); err != nil {
+		tracer.LogError(span, err)
+		return nil, badRequest(err)
+	}
+	return &s.Config{Id: config.Id, Version: config.Version, Entries: result.Entries, Labels: result.Labels}, nil
+}
+
+// swagger:route PATCH /config/{id}/{version}/ config patchConfig
+// Patch entries and labels of a config
+//
+// Accepts application/merge-patch+json and application/json-patch+json.
+// Drafts (versions tagged -draft like 1.2.0-draft) are edited in place, any
+// other version is left untouched and the result is published as the
+// version given in the target query parameter, or the next patch version.
+//
+// responses:
+//
+//	415: ErrorResponse
+//	400: ErrorResponse
+//	404: ErrorResponse
+//	409: ErrorResponse
+//	412: ErrorResponse
+//	200: ResponseConfig
+//	201: ResponseConfig
+func (cs *configServer) patchConfigHandler(w http.ResponseWriter, req *http.Request) {
+	span := tracer.StartSpanFromRequest("patchConfigHandler", cs.tracer, req)
+	defer span.Finish()
+
+	span.LogFields(
+		tracer.LogString("handler", fmt.Sprintf("handling config patch at %s\n", req.URL.Path)),
+	)
+
+	mediatype, _, err := mime.ParseMediaType(req.Header.Get("Content-Type"))
+	if err != nil {
+		writeError(w, req, span, badRequest(err))
+		return
+	}
+	if mediatype != mergePatchType && mediatype != jsonPatchType {
+		err := fmt.Errorf("%w, expect %s or %s", errUnsupportedMediaType, mergePatchType, jsonPatchType)
+		writeError(w, req, span, err)
+		return
+	}
+
+	ctx := withChange(tracer.ContextWithSpan(context.Background(), span), req)
+	id := mux.Vars(req)["id"]
+	version, err := cs.store.ResolveConfigVersion(ctx, id, mux.Vars(req)["version"])
+	if err != nil {
+		writeError(w, req, span, err)
+		return
+	}
+	labels, err := s.ParseLabels(req.URL.Query().Get("labels"))
+	if err != nil {
+		writeError(w, req, span, err)
+		return
+	}
+
+	current, err := cs.findConfig(ctx, id, version, labels)
+	if err != nil {
+		writeError(w, req, span, err)
+		return
+	}
+	if preconditionFailed(req, etag(current.Index)) {
+		writeError(w, req, span, errPreconditionFailed)
+		return
+	}
+
+	patch, err := io.ReadAll(req.Body)
+	if err != nil {
+		writeError(w, req, span, err)
+		return
+	}
+	patched, err := applyPatch(ctx, mediatype, current, patch)
+	if err != nil {
+		writeError(w, req, span, err)
+		return
+	}
+
+	target := req.URL.Query().Get("target")
+	if target == "" && s.IsDraft(current.Version) {
+		updated, err := cs.store.UpdateDraft(ctx, current, patched)
+		if err != nil {
+			writeError(w, req, span, err)
+			return
+		}
+		cs.events.configEvent(eventUpdated, updated)
+		setModifyIndex(w, updated.Index)
+		renderJSON(ctx, w, updated)
+		return
+	}
+
+	if target == "" {
+		versions, err := cs.store.ConfigVersions(ctx, id)
+		if err != nil {
+			writeError(w, req, span, err)
+			return
+		}
+		target, err = s.NextPatchVersion(versions, current.Version)
+		if err != nil {
+			writeError(w, req, span, err)
+			return
+		}
+	}
+	patched.Version = target
+	created, err := cs.store.NewConfigVersion(ctx, patched)
+	if err != nil {
+		writeError(w, req, span, err)
+		return
+	}
+	cs.events.configEvent(eventCreated, created)
+	setModifyIndex(w, created.Index)
+	renderCreated(ctx, w, configLocation(created), created)
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	s "example.com/mod/store"
+	tracer "example.com/mod/tracer"
+	opentracing "github.com/opentracing/opentracing-go"
+	"net/http"
+	"strings"
+)
+
+const problemContentType = "application/problem+json"
+
+var (
+	errUnsupportedMediaType = errors.New("unsupported Content-Type")
+
+	// errBadRequest marks errors in what the client sent, like bodies that
+	// do not decode or malformed query parameters. Errors neither marked
+	// nor in problemCodes are server errors.
+	errBadRequest = errors.New("bad request")
+)
+
+// badRequestError marks err with errBadRequest, keeping its message.
+type badRequestError struct {
+	err error
+}
+
+func (e badRequestError) Error() string        { return e.err.Error() }
+func (e badRequestError) Unwrap() error        { return e.err }
+func (e badRequestError) Is(target error) bool { return target == errBadRequest }
+
+// badRequest marks err as the client's fault, nil stays nil.
+func badRequest(err error) error {
+	if err == nil {
+		return nil
+	}
+	return badRequestError{err: err}
+}
+
+// Problem is an RFC 7807 problem details document, the body of every error
+// response.
+//
+// swagger:model Problem
+type Problem struct {
+	// Always about:blank, the code tells problems apart
+	Type string `json:"type"`
+	// Status text of the response
+	Title string `json:"title"`
+	// Status code of the response
+	Status int `json:"status"`
+	// Machine readable code, like config_not_found or version_conflict
+	Code string `json:"code"`
+	// Human readable explanation of this occurrence
+	Detail string `json:"detail,omitempty"`
+	// Path of the request
+	Instance string `json:"instance,omitempty"`
+	// Id of the trace the request was recorded in
+	TraceId string `json:"traceId,omitempty"`
+}
+
+// problemCodes maps errors to the status and code they are reported with.
+// The first match wins, so errors come before the ones they wrap.
+var problemCodes = []struct {
+	err    error
+	status int
+	code   string
+}{
+	{s.ErrConfigNotFound, http.StatusNotFound, "config_not_found"},
+	{s.ErrGroupNotFound, http.StatusNotFound, "group_not_found"},
+	{errConfigNotInGroup, http.StatusNotFound, "config_not_in_group"},
+	{s.ErrVersionNotFound, http.StatusNotFound, "version_not_found"},
+	{s.ErrNotFound, http.StatusNotFound, "not_found"},
+	{s.ErrVersionExists, http.StatusConflict, "version_conflict"},
+	{s.ErrReferenced, http.StatusConflict, "config_referenced"},
+	{s.ErrCascadeTooLarge, http.StatusConflict, "cascade_too_large"},
+	{s.ErrConflict, http.StatusConflict, "concurrent_modification"},
+	{errIdempotencyInProgress, http.StatusConflict, "idempotency_in_progress"},
+	{errPreconditionFailed, http.StatusPreconditionFailed, "precondition_failed"},
+	{errUnsupportedMediaType, http.StatusUnsupportedMediaType, "unsupported_media_type"},
+	{errIdempotencyMismatch, http.StatusUnprocessableEntity, "idempotency_key_reused"},
+	{s.ErrInvalidLabels, http.StatusBadRequest, "invalid_labels"},
+	{s.ErrInvalidSelector, http.StatusBadRequest, "invalid_selector"},
+	{s.ErrInvalidVersion, http.StatusBadRequest, "invalid_version"},
+	{errDuplicateMember, http.StatusBadRequest, "duplicate_member"},
+	{errDiffVersions, http.StatusBadRequest, "missing_versions"},
+	{errInvalidLimit, http.StatusBadRequest, "invalid_limit"},
+	{errInvalidFields, http.StatusBadRequest, "invalid_fields"},
+	{errInvalidTime, http.StatusBadRequest, "invalid_time"},
+	{s.ErrInvalidCursor, http.StatusBadRequest, "invalid_cursor"},
+	{s.ErrInvalidSort, http.StatusBadRequest, "invalid_sort"},
+	{errBadRequest, http.StatusBadRequest, "bad_request"},
+	{s.ErrWatchNotSupported, http.StatusNotImplemented, "watch_not_supported"},
+}
+
+// errorStatus is the response status for err. Errors not in problemCodes,
+// like storage failures and timeouts, are internal server errors.
+func errorStatus(err error) int {
+	for _, p := range problemCodes {
+		if errors.Is(err, p.err) {
+			return p.status
+		}
+	}
+	return http.StatusInternalServerError
+}
+
+// errorCode is the code err is reported with under status. Errors not in
+// problemCodes get the status text, like internal_server_error.
+func errorCode(err error, status int) string {
+	for _, p := range problemCodes {
+		if p.status == status && errors.Is(err, p.err) {
+			return p.code
+		}
+	}
+	return strings.ReplaceAll(strings.ToLower(http.StatusText(status)), " ", "_")
+}
+
+// writeError responds to req with err as a problem, with the status
+// errorStatus picks for it.
+func writeError(w http.ResponseWriter, req *http.Request, span opentracing.Span, err error) {
+	writeProblem(w, req, span, errorStatus(err), err)
+}
+
+// writeProblem responds to req with err as a problem with status, for
+// errors like storage failures whose status errorStatus cannot tell. The
+// trace id is taken from span, which may be nil.
+func writeProblem(w http.ResponseWriter, req *http.Request, span opentracing.Span, status int, err error) {
+	problem := newProblem(span, status, err)
+	problem.Instance = req.URL.Path
+	renderProblem(w, problem)
+}
+
+func newProblem(span opentracing.Span, status int, err error) *Problem {
+	problem := &Problem{
+		Type:   "about:blank",
+		Title:  http.StatusText(status),
+		Status: status,
+		Code:   errorCode(err, status),
+		Detail: err.Error(),
+	}
+	if span != nil {
+		problem.TraceId = tracer.TraceID(span)
+	}
+	return problem
+}
+
+func renderProblem(w http.ResponseWriter, problem *Problem) {
+	w.Header().Set("Content-Type", problemContentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(problem.Status)
+	json.NewEncoder(w).Encode(problem)
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"errors"
+	s "example.com/mod/store"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestErrorStatus(t *testing.T) {
+	for _, c := range []struct {
+		err    error
+		status int
+		code   string
+	}{
+		{fmt.Errorf("%w: c1 1.0.0", s.ErrConfigNotFound), http.StatusNotFound, "config_not_found"},
+		{fmt.Errorf("%w: g1", s.ErrGroupNotFound), http.StatusNotFound, "group_not_found"},
+		{s.ErrNotFound, http.StatusNotFound, "not_found"},
+		{s.ErrVersionExists, http.StatusConflict, "version_conflict"},
+		{fmt.Errorf("%w: 65 ops", s.ErrCascadeTooLarge), http.StatusConflict, "cascade_too_large"},
+		{s.ErrConflict, http.StatusConflict, "concurrent_modification"},
+		{s.ErrInvalidLabels, http.StatusBadRequest, "invalid_labels"},
+		{badRequest(errors.New("unexpected EOF")), http.StatusBadRequest, "bad_request"},
+		{errors.New("connection refused"), http.StatusInternalServerError, "internal_server_error"},
+	} {
+		status := errorStatus(c.err)
+		if code := errorCode(c.err, status); status != c.status || code != c.code {
+			t.Errorf("%v is reported as %d %s, want %d %s", c.err, status, code, c.status, c.code)
+		}
+	}
+}
+
+func TestProblemResponses(t *testing.T) {
+	_, ts := newTestServer(t)
+	config := createConfig(t, ts, `{"version":"1.0.0","entries":{"a":"1"}}`)
+	url := ts.URL + "/config/" + config.Id + "/"
+
+	resp, data := do(t, http.MethodGet, url+"2.0.0/", "", nil)
+	checkProblem(t, resp, data, http.StatusNotFound, "config_not_found")
+	resp, data = do(t, http.MethodPost, url, `{"version":"1.0.0"}`, nil)
+	checkProblem(t, resp, data, http.StatusConflict, "version_conflict")
+	resp, data = do(t, http.MethodPost, ts.URL+"/config/", `{"version":"1.0.0"`, nil)
+	checkProblem(t, resp, data, http.StatusBadRequest, "bad_request")
+	resp, data = do(t, http.MethodPost, ts.URL+"/config/", `{"version":"1.0.0","labels":{"env=":"prod"}}`, nil)
+	checkProblem(t, resp, data, http.StatusBadRequest, "invalid_labels")
+	resp, data = do(t, http.MethodPost, ts.URL+"/config/", `{"version":"1.0.0"}`, map[string]string{"Content-Type": "text/plain"})
+	checkProblem(t, resp, data, http.StatusUnsupportedMediaType, "unsupported_media_type")
+	resp, data = do(t, http.MethodGet, ts.URL+"/configs/?limit=-1", "", nil)
+	checkProblem(t, resp, data, http.StatusBadRequest, "invalid_limit")
+
+	members := `{"version":"1.0.0","configs":[{"id":"` + config.Id + `","version":"1.0.0","entries":{"a":"1"}}]}`
+	for i := 0; i < 40; i++ {
+		createGroup(t, ts, members)
+	}
+	resp, data = do(t, http.MethodDelete, url+"1.0.0/", "", nil)
+	checkProblem(t, resp, data, http.StatusConflict, "config_referenced")
+	resp, data = do(t, http.MethodDelete, url+"1.0.0/?cascade=true", "", nil)
+	checkProblem(t, resp, data, http.StatusConflict, "cascade_too_large")
+	if !strings.Contains(data, "40 groups") {
+		t.Errorf("expected the detail to count the groups, got %s", data)
+	}
+}
@@ -0,0 +1,5 @@
+// Package proto holds the gRPC API of the config service. The Go code is
+// generated from alati.proto.
+package proto
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative alati.proto
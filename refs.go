@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"errors"
+	s "example.com/mod/store"
+	tracer "example.com/mod/tracer"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// resolveRef loads the config ref points at. A ref that does not resolve,
+// because the config or a matching version is gone, is a wrapped
+// store.ErrConfigNotFound.
+func (cs *configServer) resolveRef(ctx context.Context, ref s.ConfigRef) (*s.Config, error) {
+	version, err := cs.store.ResolveConfigVersion(ctx, ref.Id, ref.Version)
+	if errors.Is(err, s.ErrVersionNotFound) {
+		return nil, fmt.Errorf("%w: %s version %s", s.ErrConfigNotFound, ref.Id, ref.Version)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return cs.findConfig(ctx, ref.Id, version, ref.Labels)
+}
+
+// resolveMembers loads the configs refs point at. All missing references
+// are reported together, wrapped in store.ErrConfigNotFound.
+func (cs *configServer) resolveMembers(ctx context.Context, refs []s.ConfigRef) ([]s.Config, error) {
+	configs := []s.Config{}
+	missing := []string{}
+	seen := map[string]bool{}
+	for _, ref := range refs {
+		key := ref.Id + "/" + ref.Labels.String()
+		if seen[key] {
+			return nil, fmt.Errorf("%w: %s", errDuplicateMember, ref.Id)
+		}
+		seen[key] = true
+
+		config, err := cs.resolveRef(ctx, ref)
+		if errors.Is(err, s.ErrNotFound) {
+			missing = append(missing, ref.Id+"@"+ref.Version)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		configs = append(configs, *config)
+	}
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("%w: %s", s.ErrConfigNotFound, strings.Join(missing, ", "))
+	}
+	return configs, nil
+}
+
+// resolveGroups resolves the refs of reference mode groups as they are
+// read. Refs that no longer resolve are listed in Dangling, with expand the
+// configs they point at are filled into Configs. The returned modify
+// indexes of those configs belong into the ETag, the group's own index does
+// not change when a referenced config does.
+func (cs *configServer) resolveGroups(ctx context.Context, groups []*s.Group, expand bool) ([]uint64, error) {
+	span := tracer.StartSpanFromContext(ctx, "resolveGroups")
+	defer span.Finish()
+	ctx = tracer.ContextWithSpan(ctx, span)
+
+	indexes := []uint64{}
+	for _, group := range groups {
+		if !group.IsReference() {
+			continue
+		}
+		group.Dangling = nil
+		for _, ref := range group.Refs {
+			config, err := cs.resolveRef(ctx, ref)
+			if errors.Is(err, s.ErrNotFound) {
+				group.Dangling = append(group.Dangling, ref)
+				continue
+			}
+			if err != nil {
+				tracer.LogError(span, err)
+				return nil, err
+			}
+			indexes = append(indexes, config.Index)
+			if expand {
+				group.Configs = append(group.Configs, *config)
+			}
+		}
+	}
+	return indexes, nil
+}
+
+// resolveGroupsTag is resolveGroups for responses, it returns the ETag of
+// groups as read. Preconditions of writes compare against groupsTag, which
+// computes the same tag.
+func (cs *configServer) resolveGroupsTag(ctx context.Context, groups []*s.Group, expand bool) (string, error) {
+	refs, err := cs.resolveGroups(ctx, groups, expand)
+	if err != nil {
+		return "", err
+	}
+	return groupsETag(groups, refs...), nil
+}
+
+// groupsTag is the ETag GET serves for groups, without changing them. For
+// reference mode groups it covers the configs the refs resolve to, so an
+// If-Match taken from a read has to be checked against it rather than
+// against the group's own index.
+func (cs *configServer) groupsTag(ctx context.Context, groups []*s.Group) (string, error) {
+	copies := make([]*s.Group, 0, len(groups))
+	for _, group := range groups {
+		c := *group
+		copies = append(copies, &c)
+	}
+	return cs.resolveGroupsTag(ctx, copies, false)
+}
+
+// ifMatch is the precondition of group updates made over REST: the
+// If-Match header of req has to match the current groupsTag. No header
+// accepts any group.
+func (cs *configServer) ifMatch(ctx context.Context, req *http.Request) func(*s.Group) error {
+	header := req.Header.Get("If-Match")
+	if header == "" {
+		return nil
+	}
+	return func(group *s.Group) error {
+		tag, err := cs.groupsTag(ctx, []*s.Group{group})
+		if err != nil {
+			return err
+		}
+		if !matchETag(header, tag) {
+			return errPreconditionFailed
+		}
+		return nil
+	}
+}
+
+// setGroupModifyIndex is setModifyIndex for a written group, with the
+// ETag a following GET serves so it can be sent back as If-Match.
+func (cs *configServer) setGroupModifyIndex(ctx context.Context, w http.ResponseWriter, group *s.Group) {
+	setModifyIndex(w, group.Index)
+	if !group.IsReference() {
+		return
+	}
+	if tag, err := cs.groupsTag(ctx, []*s.Group{group}); err == nil {
+		w.Header().Set("ETag", tag)
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	s "example.com/mod/store"
+	"github.com/gorilla/mux"
+	"github.com/opentracing/opentracing-go"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestReferenceGroupIfMatch(t *testing.T) {
+	ctx := context.Background()
+	cs := &configServer{store: s.NewMemory(), events: newEventBroker(), tracer: opentracing.NoopTracer{}}
+
+	config, err := cs.store.Config(ctx, &s.Config{Version: "1.0.0", Entries: map[string]string{"a": "1"}})
+	if err != nil {
+		t.Fatalf("Config failed: %v", err)
+	}
+	group, err := cs.store.PostGroup(ctx, &s.Group{
+		Version: "1.0.0",
+		Mode:    s.ReferenceMode,
+		Refs:    []s.ConfigRef{{Id: config.Id, Version: "^1.0"}},
+	})
+	if err != nil {
+		t.Fatalf("PostGroup failed: %v", err)
+	}
+	vars := map[string]string{"id": group.Id, "version": group.Version}
+
+	get := httptest.NewRequest(http.MethodGet, "/group/"+group.Id+"/1.0.0/", nil)
+	w := httptest.NewRecorder()
+	cs.getGroupHandler(w, mux.SetURLVars(get, vars))
+	tag := w.Header().Get("ETag")
+	if w.Code != http.StatusOK || tag == "" {
+		t.Fatalf("GET returned %d with ETag %q", w.Code, tag)
+	}
+
+	put := func(ifMatch string) *httptest.ResponseRecorder {
+		body := `{"configs":[{"id":"` + config.Id + `","version":"1.0.0"}]}`
+		req := httptest.NewRequest(http.MethodPut, "/group/"+group.Id+"/1.0.0/", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("If-Match", ifMatch)
+		w := httptest.NewRecorder()
+		cs.replaceGroupConfigsHandler(w, mux.SetURLVars(req, vars))
+		return w
+	}
+
+	w = put(tag)
+	if w.Code != http.StatusOK {
+		t.Fatalf("PUT with the ETag of GET returned %d: %s", w.Code, w.Body)
+	}
+	if w = put(tag); w.Code != http.StatusPreconditionFailed {
+		t.Errorf("PUT with a stale ETag returned %d", w.Code)
+	}
+}
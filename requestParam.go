@@ -16,7 +16,7 @@ type GetConfigRequest struct {
 	Id string `json:"id"`
 }
 
-// swagger:parameters config createConfig
+// swagger:parameters config createConfig createConfigVersion
 type RequestConfigBody struct {
 	// - name: body
 	//  in: body
@@ -28,7 +28,7 @@ type RequestConfigBody struct {
 	Body store.Config `json:"body"`
 }
 
-// swagger:parameters config createGroup
+// swagger:parameters config createGroup createGroupVersion
 type RequestGroupBody struct {
 	// - name: body
 	//  in: body
@@ -74,3 +74,166 @@ type DeleteConfigFromGroupRequest struct {
 	// in: path
 	ConfigId string `json:"c_id"`
 }
+
+// swagger:parameters getConfigById getGroupById
+type WatchRequest struct {
+	// Block until the resource changes
+	// in: query
+	Watch bool `json:"watch"`
+
+	// Index returned in X-Modify-Index by the previous call
+	// in: query
+	Index uint64 `json:"index"`
+
+	// How long to block, e.g. 30s (max 10m)
+	// in: query
+	Wait string `json:"wait"`
+}
+
+// swagger:parameters getConfigs
+type LabelsRequest struct {
+	// Labels every returned config has, e.g. team=payments,env=prod
+	// in: query
+	Labels string `json:"labels"`
+}
+
+// swagger:parameters getGroupsByLabels deleteGroupByLabels
+type GroupLabelsRequest struct {
+	// Group ID
+	// in: path
+	Id string `json:"id"`
+
+	// Group version
+	// in: path
+	Version string `json:"version"`
+
+	// Labels in k=v,k2=v2 form, in any order
+	// in: path
+	Labels string `json:"labels"`
+}
+
+// swagger:parameters getConfigs getConfigById getGroups getGroupById
+type SelectorRequest struct {
+	// Label selector, e.g. env=prod,region in (eu,us),!canary
+	// in: query
+	Selector string `json:"selector"`
+}
+
+// swagger:parameters getConfigVersions getGroupVersions createConfigVersion createGroupVersion
+type VersionsRequest struct {
+	// Config or group ID
+	// in: path
+	Id string `json:"id"`
+}
+
+// swagger:parameters diffConfig diffGroup
+type DiffRequest struct {
+	// Config or group ID
+	// in: path
+	Id string `json:"id"`
+
+	// Version to diff from, accepts latest and ranges like ^1.2
+	// in: query
+	From string `json:"from"`
+
+	// Version to diff to, accepts latest and ranges like ^1.2
+	// in: query
+	To string `json:"to"`
+}
+
+// swagger:parameters diffConfig
+type DiffLabelsRequest struct {
+	// Labels of the config versions to compare, in k=v,k2=v2 form
+	// in: query
+	Labels string `json:"labels"`
+}
+
+// swagger:parameters patchConfig
+type PatchConfigRequest struct {
+	// Config ID
+	// in: path
+	Id string `json:"id"`
+
+	// Version to patch, accepts latest and ranges like ^1.2
+	// in: path
+	Version string `json:"version"`
+
+	// Version to publish the result as, defaults to the next patch version
+	// in: query
+	Target string `json:"target"`
+
+	// Labels of the config to patch, in k=v,k2=v2 form
+	// in: query
+	Labels string `json:"labels"`
+
+	// Merge patch or JSON patch against {"entries": {...}, "labels": {...}}
+	// in: body
+	Body map[string]interface{} `json:"body"`
+}
+
+// swagger:parameters replaceGroupConfigs
+type ReplaceGroupConfigsRequest struct {
+	// Group ID
+	// in: path
+	Id string `json:"id"`
+
+	// Group version
+	// in: path
+	Version string `json:"version"`
+
+	// The configs the group should hold, as {"configs": [{"id": "..", "version": ".."}]}
+	// in: body
+	Body struct {
+		Configs []store.ConfigRef `json:"configs"`
+	} `json:"body"`
+}
+
+// swagger:parameters getGroups getGroupById getGroupsByLabels
+type ExpandRequest struct {
+	// Fill configs of reference mode groups with the configs their refs resolve to
+	// in: query
+	Expand bool `json:"expand"`
+}
+
+// swagger:parameters deleteConfig
+type CascadeRequest struct {
+	// Remove the config from the groups holding it instead of failing with 409
+	// in: query
+	Cascade bool `json:"cascade"`
+}
+
+// swagger:parameters createConfig createConfigVersion patchConfig createGroup createGroupVersion addConfigToGroup
+type IdempotencyKeyRequest struct {
+	// Retries with the same key on the same path get the first response replayed
+	// in: header
+	IdempotencyKey string `json:"x-idempotency-key"`
+}
+
+// swagger:parameters createConfig createConfigVersion patchConfig deleteConfig createGroup createGroupVersion addConfigToGroup replaceGroupConfigs deleteConfigFromGroup
+type ChangeRequest struct {
+	// Recorded as createdBy and updatedBy of the written versions
+	// in: header
+	Author string `json:"X-Author"`
+	// Recorded as changeDescription of the written versions
+	// in: header
+	ChangeDescription string `json:"X-Change-Description"`
+}
+
+// swagger:parameters getConfigs getGroups
+type PageRequest struct {
+	// Most items to return, 1 to 1000, default 100
+	// in: query
+	Limit int `json:"limit"`
+	// X-Next-Cursor of the previous page
+	// in: query
+	Cursor string `json:"cursor"`
+	// id, version or created, with a leading - for descending order
+	// in: query
+	Sort string `json:"sort"`
+	// Comma separated fields to return, like id,version
+	// in: query
+	Fields string `json:"fields"`
+	// RFC 3339 time, only items written at or after it are returned
+	// in: query
+	UpdatedSince string `json:"updatedSince"`
+}
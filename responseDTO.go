@@ -22,14 +22,30 @@ type ResponseGroup struct {
 	Configs []store.Config `json:"configs"`
 }
 
+// swagger:response VersionsResponse
+type VersionsResponse struct {
+	// Versions, lowest first
+	// in: body
+	Versions []string
+}
+
+// swagger:response ConfigDiffResponse
+type ConfigDiffResponse struct {
+	// in: body
+	Body ConfigDiff
+}
+
+// swagger:response GroupDiffResponse
+type GroupDiffResponse struct {
+	// in: body
+	Body GroupDiff
+}
+
 // swagger:response ErrorResponse
 type ErrorResponse struct {
-	// Error status code
-	// in: int64
-	Status int64 `json:"status"`
-	// Message of the error
-	// in: string
-	Message string `json:"message"`
+	// RFC 7807 problem details, served as application/problem+json
+	// in: body
+	Body Problem
 }
 
 // swagger:response NoContentResponse
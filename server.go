@@ -11,21 +11,42 @@ import (
 	"io"
 	"mime"
 	"net/http"
+	"strconv"
+	"time"
 )
 
 const (
 	name = "post_service"
+
+	// groupUpdateRetries is how many times a group update is retried when
+	// it races with another one.
+	groupUpdateRetries = 5
+)
+
+var (
+	errConfigNotInGroup   = errors.New("config not found in group")
+	errPreconditionFailed = errors.New("resource does not match If-Match")
+	errDuplicateMember    = errors.New("config referenced more than once")
 )
 
 type configServer struct {
-	store  *s.Store
+	store  s.Store
+	events *eventBroker
 	tracer opentracing.Tracer
 	closer io.Closer
+
+	// idempotencyTTL is how long responses to requests with an
+	// idempotency key are replayed, see idempotent.
+	idempotencyTTL time.Duration
 	//data      map[string]*s.Config
 	//groupData map[string]*s.Group
 }
 
 func NewPostServer() (*configServer, error) {
+	ttl, err := idempotencyTTL()
+	if err != nil {
+		return nil, err
+	}
 	store, err := s.New()
 	if err != nil {
 		return nil, err
@@ -34,9 +55,11 @@ func NewPostServer() (*configServer, error) {
 	tracer, closer := tracer.Init(name)
 	opentracing.SetGlobalTracer(tracer)
 	return &configServer{
-		store:  store,
-		tracer: tracer,
-		closer: closer,
+		store:          store,
+		events:         newEventBroker(),
+		tracer:         tracer,
+		closer:         closer,
+		idempotencyTTL: ttl,
 	}, nil
 }
 func (s *configServer) GetTracer() opentracing.Tracer {
@@ -68,51 +91,96 @@ func (cs *configServer) createConfigHandler(w http.ResponseWriter, req *http.Req
 	)
 
 	contentType := req.Header.Get("Content-Type")
-	requestId := req.Header.Get("x-idempotency-key")
 	mediatype, _, err := mime.ParseMediaType(contentType)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeError(w, req, span, badRequest(err))
 		return
 	}
 	if mediatype != "application/json" {
-		err := errors.New("Expect application/json Content-Type")
-		http.Error(w, err.Error(), http.StatusUnsupportedMediaType)
+		err := fmt.Errorf("%w, expect application/json", errUnsupportedMediaType)
+		writeError(w, req, span, err)
 		return
 	}
-	ctx := tracer.ContextWithSpan(context.Background(), span)
+	ctx := withChange(tracer.ContextWithSpan(context.Background(), span), req)
 	rt, err := decodeBody(ctx, req.Body)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeError(w, req, span, err)
 		return
 	}
 
 	//post, err := cs.store.Config(rt)
 	/*if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeError(w, req, span, err)
 		return
 	}*/
 
-	if cs.store.FindRequestId(ctx, requestId) == true {
-		http.Error(w, "Request has been already sent", http.StatusBadRequest)
+	post, err := cs.store.Config(ctx, rt)
+	if err != nil {
+		writeError(w, req, span, err)
 		return
 	}
-	post, err := cs.store.Config(ctx, rt)
+	cs.events.configEvent(eventCreated, post)
+	setModifyIndex(w, post.Index)
+	renderCreated(ctx, w, configLocation(post), post)
+}
 
-	reqId := ""
+// swagger:route POST /config/{id}/ config createConfigVersion
+// Publish a new version of an existing config
+//
+// responses:
+//
+//	415: ErrorResponse
+//	400: ErrorResponse
+//	404: ErrorResponse
+//	409: ErrorResponse
+//	201: ResponseConfig
+func (cs *configServer) createConfigVersionHandler(w http.ResponseWriter, req *http.Request) {
+	span := tracer.StartSpanFromRequest("createConfigVersionHandler", cs.tracer, req)
+	defer span.Finish()
+
+	span.LogFields(
+		tracer.LogString("handler", fmt.Sprintf("handling config version create at %s\n", req.URL.Path)),
+	)
 
-	if err == nil {
-		reqId = cs.store.SaveRequestId(ctx)
+	contentType := req.Header.Get("Content-Type")
+	mediatype, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		writeError(w, req, span, badRequest(err))
+		return
 	}
+	if mediatype != "application/json" {
+		err := fmt.Errorf("%w, expect application/json", errUnsupportedMediaType)
+		writeError(w, req, span, err)
+		return
+	}
+	ctx := withChange(tracer.ContextWithSpan(context.Background(), span), req)
+	rt, err := decodeBody(ctx, req.Body)
+	if err != nil {
+		writeError(w, req, span, err)
+		return
+	}
+	rt.Id = mux.Vars(req)["id"]
 
-	renderJSON(ctx, w, post)
-	renderJSON(ctx, w, "Idempotence key:"+reqId)
+	post, err := cs.store.NewConfigVersion(ctx, rt)
+	if err != nil {
+		writeError(w, req, span, err)
+		return
+	}
+	cs.events.configEvent(eventCreated, post)
+	setModifyIndex(w, post.Index)
+	renderCreated(ctx, w, configLocation(post), post)
 }
 
 // swagger:route GET /configs/ config getConfigs
-// Get all configs
+// Get all configs, optionally only those with all of labels or matching a
+// label selector
+//
+// Returns one page of at most limit configs, the next page is linked in
+// the Link header.
 //
 // responses:
 //
+//	400: ErrorResponse
 //	200: []ResponseConfig
 func (cs *configServer) getAllHandler(w http.ResponseWriter, req *http.Request) {
 	span := tracer.StartSpanFromRequest("getAllConfigsHandler", cs.tracer, req)
@@ -123,12 +191,43 @@ func (cs *configServer) getAllHandler(w http.ResponseWriter, req *http.Request)
 	)
 
 	ctx := tracer.ContextWithSpan(context.Background(), span)
-	allTasks, err := cs.store.GetAll(ctx)
+	selector, err := s.ParseSelector(req.URL.Query().Get("selector"))
+	if err != nil {
+		writeError(w, req, span, err)
+		return
+	}
+
+	opts, err := pageQuery(req)
+	if err != nil {
+		writeError(w, req, span, err)
+		return
+	}
+	opts.Selector = selector
+	if query := req.URL.Query().Get("labels"); query != "" {
+		opts.Labels, err = s.ParseLabels(query)
+		if err != nil {
+			writeError(w, req, span, err)
+			return
+		}
+	}
+	fields, err := fieldsQuery(req, s.Config{})
+	if err != nil {
+		writeError(w, req, span, err)
+		return
+	}
+
+	allTasks, next, err := cs.store.ListConfigs(ctx, opts)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeError(w, req, span, err)
 		return
 	}
-	renderJSON(ctx, w, allTasks)
+	body, err := project(allTasks, fields)
+	if err != nil {
+		writeProblem(w, req, span, http.StatusInternalServerError, err)
+		return
+	}
+	setNextPage(w, req, next)
+	renderJSON(ctx, w, body)
 }
 
 // swagger:route GET /config/{id}/ config getConfigById
@@ -137,6 +236,7 @@ func (cs *configServer) getAllHandler(w http.ResponseWriter, req *http.Request)
 // responses:
 //
 //	404: ErrorResponse
+//	304: NoContentResponse
 //	200: ResponseConfig
 func (cs *configServer) getConfigHandler(w http.ResponseWriter, req *http.Request) {
 	span := tracer.StartSpanFromRequest("getConfigHandler", cs.tracer, req)
@@ -148,23 +248,60 @@ func (cs *configServer) getConfigHandler(w http.ResponseWriter, req *http.Reques
 
 	ctx := tracer.ContextWithSpan(context.Background(), span)
 	id := mux.Vars(req)["id"]
-	version := mux.Vars(req)["version"]
+	version, err := cs.store.ResolveConfigVersion(ctx, id, mux.Vars(req)["version"])
+	if err != nil {
+		writeError(w, req, span, err)
+		return
+	}
 
-	task, err := cs.store.Get(ctx, id, version)
+	watch, index, wait, err := watchQuery(req)
+	if err != nil {
+		writeError(w, req, span, err)
+		return
+	}
 
+	selector, err := s.ParseSelector(req.URL.Query().Get("selector"))
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeError(w, req, span, err)
 		return
 	}
+
+	var task []*s.Config
+	if watch {
+		task, index, err = cs.store.WaitConfigs(tracer.ContextWithSpan(req.Context(), span), id, version, index, wait)
+		w.Header().Set("X-Modify-Index", strconv.FormatUint(index, 10))
+		task = selectConfigs(task, selector)
+	} else {
+		task, err = cs.store.GetConfigsBySelector(ctx, id, version, selector)
+	}
+
+	if err != nil {
+		writeError(w, req, span, err)
+		return
+	}
+	if len(task) == 0 {
+		writeError(w, req, span, configNotFound(id, version))
+		return
+	}
+	tag := configsETag(task)
+	if notModified(w, req, tag) {
+		return
+	}
+	w.Header().Set("ETag", tag)
 	renderJSON(ctx, w, task)
 }
 
 // swagger:route DELETE /config/{id}/ config deleteConfig
 // Delete config
 //
+// Fails with 409 while groups hold the config, unless cascade=true removes
+// it from them.
+//
 // responses:
 //
 //	404: ErrorResponse
+//	409: ErrorResponse
+//	412: ErrorResponse
 //	204: NoContentResponse
 //	201: ResponseConfig
 func (cs *configServer) delConfigHandler(w http.ResponseWriter, req *http.Request) {
@@ -175,16 +312,41 @@ func (cs *configServer) delConfigHandler(w http.ResponseWriter, req *http.Reques
 		tracer.LogString("handler", fmt.Sprintf("handling delete config at %s\n", req.URL.Path)),
 	)
 
-	ctx := tracer.ContextWithSpan(context.Background(), span)
+	ctx := withChange(tracer.ContextWithSpan(context.Background(), span), req)
 	id := mux.Vars(req)["id"]
 
 	version := mux.Vars(req)["version"]
+	cascade, err := boolQuery(req, "cascade")
+	if err != nil {
+		writeError(w, req, span, err)
+		return
+	}
 
-	msg, err := cs.store.Delete(ctx, id, version)
+	var msg map[string]string
+	var groups []*s.Group
+	if req.Header.Get("If-Match") != "" {
+		var current []*s.Config
+		if current, err = cs.store.Get(ctx, id, version); err != nil {
+			writeError(w, req, span, err)
+			return
+		}
+		if preconditionFailed(req, configsETag(current)) {
+			writeError(w, req, span, errPreconditionFailed)
+			return
+		}
+		msg = map[string]string{"Deleted": id}
+		groups, err = cs.store.DeleteConfigs(ctx, current, cascade)
+	} else {
+		msg, groups, err = cs.store.Delete(ctx, id, version, cascade)
+	}
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeError(w, req, span, err)
 		return
 	}
+	cs.events.configEvent(eventDeleted, &s.Config{Id: id, Version: version})
+	for _, group := range groups {
+		cs.events.groupEvent(eventUpdated, group)
+	}
 	renderJSON(ctx, w, msg)
 }
 func (cs *configServer) delConfigByLabelHandler(w http.ResponseWriter, req *http.Request) {
@@ -195,18 +357,52 @@ func (cs *configServer) delConfigByLabelHandler(w http.ResponseWriter, req *http
 		tracer.LogString("handler", fmt.Sprintf("handling delete config at %s\n", req.URL.Path)),
 	)
 
-	ctx := tracer.ContextWithSpan(context.Background(), span)
+	ctx := withChange(tracer.ContextWithSpan(context.Background(), span), req)
 	id := mux.Vars(req)["id"]
 
 	version := mux.Vars(req)["version"]
 
 	label := mux.Vars(req)["labels"]
+	labels, err := s.ParseLabels(label)
+	if err != nil {
+		writeError(w, req, span, err)
+		return
+	}
+	cascade, err := boolQuery(req, "cascade")
+	if err != nil {
+		writeError(w, req, span, err)
+		return
+	}
 
-	msg, err := cs.store.DeleteByLabel(ctx, id, version, label)
+	var msg map[string]string
+	var groups []*s.Group
+	if req.Header.Get("If-Match") != "" {
+		var current []*s.Config
+		if current, err = cs.store.GetConfigsByLabels(ctx, id, version, label); err != nil {
+			writeError(w, req, span, err)
+			return
+		}
+		if len(current) == 0 {
+			writeError(w, req, span, configNotFound(id, version))
+			return
+		}
+		if preconditionFailed(req, configsETag(current)) {
+			writeError(w, req, span, errPreconditionFailed)
+			return
+		}
+		msg = map[string]string{"Deleted": id}
+		groups, err = cs.store.DeleteConfigs(ctx, current, cascade)
+	} else {
+		msg, groups, err = cs.store.DeleteByLabel(ctx, id, version, label, cascade)
+	}
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeError(w, req, span, err)
 		return
 	}
+	cs.events.configEvent(eventDeleted, &s.Config{Id: id, Version: version, Labels: labels})
+	for _, group := range groups {
+		cs.events.groupEvent(eventUpdated, group)
+	}
 	renderJSON(ctx, w, msg)
 }
 
@@ -234,45 +430,93 @@ func (cs *configServer) createGroupHandler(w http.ResponseWriter, req *http.Requ
 		tracer.LogString("handler", fmt.Sprintf("handling group create at %s\n", req.URL.Path)),
 	)
 	contentType := req.Header.Get("Content-Type")
-	requestId := req.Header.Get("x-idempotency-key")
 	mediatype, _, err := mime.ParseMediaType(contentType)
 	if err != nil {
 
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeError(w, req, span, badRequest(err))
 		return
 	}
 	if mediatype != "application/json" {
-		err := errors.New("Expect application/json Content-Type")
-		http.Error(w, err.Error(), http.StatusUnsupportedMediaType)
+		err := fmt.Errorf("%w, expect application/json", errUnsupportedMediaType)
+		writeError(w, req, span, err)
 		return
 	}
-	ctx := tracer.ContextWithSpan(context.Background(), span)
+	ctx := withChange(tracer.ContextWithSpan(context.Background(), span), req)
 	rt, err := decodeGroup(ctx, req.Body)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeError(w, req, span, err)
+		return
+	}
+	if _, err := cs.resolveMembers(ctx, rt.Refs); err != nil {
+		writeError(w, req, span, err)
 		return
 	}
 
 	//post, err := cs.store.PostGroup(rt)
 	/*if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeError(w, req, span, err)
 		return
 	}*/
 
-	if cs.store.FindRequestId(ctx, requestId) == true {
-		http.Error(w, "Request has been already sent", http.StatusBadRequest)
+	post, err := cs.store.PostGroup(ctx, rt)
+	if err != nil {
+		writeError(w, req, span, err)
 		return
 	}
-	post, err := cs.store.PostGroup(ctx, rt)
+	cs.events.groupEvent(eventCreated, post)
+	cs.setGroupModifyIndex(ctx, w, post)
+	renderCreated(ctx, w, groupLocation(post), post)
+}
 
-	reqId := ""
+// swagger:route POST /group/{id}/ group createGroupVersion
+// Publish a new version of an existing group
+//
+// responses:
+//
+//	415: ErrorResponse
+//	400: ErrorResponse
+//	404: ErrorResponse
+//	409: ErrorResponse
+//	201: ResponseGroup
+func (cs *configServer) createGroupVersionHandler(w http.ResponseWriter, req *http.Request) {
+	span := tracer.StartSpanFromRequest("createGroupVersionHandler", cs.tracer, req)
+	defer span.Finish()
+
+	span.LogFields(
+		tracer.LogString("handler", fmt.Sprintf("handling group version create at %s\n", req.URL.Path)),
+	)
 
-	if err == nil {
-		reqId = cs.store.SaveRequestId(ctx)
+	contentType := req.Header.Get("Content-Type")
+	mediatype, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		writeError(w, req, span, badRequest(err))
+		return
+	}
+	if mediatype != "application/json" {
+		err := fmt.Errorf("%w, expect application/json", errUnsupportedMediaType)
+		writeError(w, req, span, err)
+		return
+	}
+	ctx := withChange(tracer.ContextWithSpan(context.Background(), span), req)
+	rt, err := decodeGroup(ctx, req.Body)
+	if err != nil {
+		writeError(w, req, span, err)
+		return
 	}
+	if _, err := cs.resolveMembers(ctx, rt.Refs); err != nil {
+		writeError(w, req, span, err)
+		return
+	}
+	rt.Id = mux.Vars(req)["id"]
 
-	renderJSON(ctx, w, post)
-	renderJSON(ctx, w, "Idempotence key:"+reqId)
+	post, err := cs.store.NewGroupVersion(ctx, rt)
+	if err != nil {
+		writeError(w, req, span, err)
+		return
+	}
+	cs.events.groupEvent(eventCreated, post)
+	cs.setGroupModifyIndex(ctx, w, post)
+	renderCreated(ctx, w, groupLocation(post), post)
 }
 
 // swagger:route PUT /group/{g_id}/config/{c_id}/ group addConfigToGroup
@@ -282,6 +526,8 @@ func (cs *configServer) createGroupHandler(w http.ResponseWriter, req *http.Requ
 //
 //	415: ErrorResponse
 //	400: ErrorResponse
+//	409: ErrorResponse
+//	412: ErrorResponse
 //	201: ResponseGroup
 func (cs *configServer) addConfigToGroup(w http.ResponseWriter, req *http.Request) {
 	span := tracer.StartSpanFromRequest("addConfigToGroupHandler", cs.tracer, req)
@@ -291,29 +537,34 @@ func (cs *configServer) addConfigToGroup(w http.ResponseWriter, req *http.Reques
 		tracer.LogString("handler", fmt.Sprintf("handling add config to group at %s\n", req.URL.Path)),
 	)
 
-	ctx := tracer.ContextWithSpan(context.Background(), span)
+	ctx := withChange(tracer.ContextWithSpan(context.Background(), span), req)
 	groupId := mux.Vars(req)["g_id"]
 	groupVersion := mux.Vars(req)["g_version"]
 	id := mux.Vars(req)["c_id"]
-	configVersion := mux.Vars(req)["c_version"]
-
-	group, err := cs.store.GetOneGroup(ctx, groupId, groupVersion)
+	configVersion, err := cs.store.ResolveConfigVersion(ctx, id, mux.Vars(req)["c_version"])
 	if err != nil {
-		err := errors.New("group not found")
-		http.Error(w, err.Error(), http.StatusNotFound)
+		writeError(w, req, span, err)
 		return
 	}
 
 	task, err := cs.store.GetOneConfig(ctx, id, configVersion)
 	if err != nil {
-		err := errors.New("config not found")
-		http.Error(w, err.Error(), http.StatusNotFound)
+		writeError(w, req, span, err)
 		return
 	}
 
-	group.Configs = append(group.Configs, *task)
-
-	cs.store.SaveGroup(ctx, group)
+	group, err := cs.updateGroup(ctx, cs.ifMatch(ctx, req), func() (*s.Group, error) {
+		return cs.store.GetOneGroup(ctx, groupId, groupVersion)
+	}, func(group *s.Group) error {
+		addGroupMember(group, task, mux.Vars(req)["c_version"])
+		return nil
+	})
+	if err != nil {
+		writeError(w, req, span, err)
+		return
+	}
+	cs.setGroupModifyIndex(ctx, w, group)
+	cs.events.groupEvent(eventUpdated, group)
 	renderJSON(ctx, w, group)
 }
 func (cs *configServer) addConfigToGroup2(w http.ResponseWriter, req *http.Request) {
@@ -324,35 +575,107 @@ func (cs *configServer) addConfigToGroup2(w http.ResponseWriter, req *http.Reque
 		tracer.LogString("handler", fmt.Sprintf("handling add config to group at %s\n", req.URL.Path)),
 	)
 
-	ctx := tracer.ContextWithSpan(context.Background(), span)
+	ctx := withChange(tracer.ContextWithSpan(context.Background(), span), req)
 	groupId := mux.Vars(req)["g_id"]
 	id := mux.Vars(req)["c_id"]
 
-	group, err := cs.store.GetOneGroup2(ctx, groupId)
+	task, err := cs.store.GetOneConfig2(ctx, id)
 	if err != nil {
-		err := errors.New("group not found")
-		http.Error(w, err.Error(), http.StatusNotFound)
+		writeError(w, req, span, err)
 		return
 	}
 
-	task, err := cs.store.GetOneConfig2(ctx, id)
+	group, err := cs.updateGroup(ctx, cs.ifMatch(ctx, req), func() (*s.Group, error) {
+		return cs.store.GetOneGroup2(ctx, groupId)
+	}, func(group *s.Group) error {
+		addGroupMember(group, task, task.Version)
+		return nil
+	})
 	if err != nil {
-		err := errors.New("config not found")
-		http.Error(w, err.Error(), http.StatusNotFound)
+		writeError(w, req, span, err)
+		return
+	}
+	cs.setGroupModifyIndex(ctx, w, group)
+	cs.events.groupEvent(eventUpdated, group)
+	renderJSON(ctx, w, group)
+}
+
+// swagger:route PUT /group/{id}/{version}/ group replaceGroupConfigs
+// Replace all configs of a group
+//
+// Takes the complete list of config references. Every reference has to
+// resolve to a stored config, otherwise the group is left unchanged.
+//
+// responses:
+//
+//	415: ErrorResponse
+//	400: ErrorResponse
+//	404: ErrorResponse
+//	409: ErrorResponse
+//	412: ErrorResponse
+//	200: ResponseGroup
+func (cs *configServer) replaceGroupConfigsHandler(w http.ResponseWriter, req *http.Request) {
+	span := tracer.StartSpanFromRequest("replaceGroupConfigsHandler", cs.tracer, req)
+	defer span.Finish()
+
+	span.LogFields(
+		tracer.LogString("handler", fmt.Sprintf("handling replace group configs at %s\n", req.URL.Path)),
+	)
+
+	mediatype, _, err := mime.ParseMediaType(req.Header.Get("Content-Type"))
+	if err != nil {
+		writeError(w, req, span, badRequest(err))
+		return
+	}
+	if mediatype != "application/json" {
+		err := fmt.Errorf("%w, expect application/json", errUnsupportedMediaType)
+		writeError(w, req, span, err)
 		return
 	}
 
-	group.Configs = append(group.Configs, *task)
+	ctx := withChange(tracer.ContextWithSpan(context.Background(), span), req)
+	id := mux.Vars(req)["id"]
+	version := mux.Vars(req)["version"]
+
+	members, err := decodeMembers(ctx, req.Body)
+	if err != nil {
+		writeError(w, req, span, err)
+		return
+	}
+	configs, err := cs.resolveMembers(ctx, members.Configs)
+	if err != nil {
+		writeError(w, req, span, err)
+		return
+	}
 
-	cs.store.SaveGroup(ctx, group)
+	group, err := cs.updateGroup(ctx, cs.ifMatch(ctx, req), func() (*s.Group, error) {
+		return cs.store.GetOneGroup(ctx, id, version)
+	}, func(group *s.Group) error {
+		if group.IsReference() {
+			group.Refs = members.Configs
+			return nil
+		}
+		group.Configs = configs
+		return nil
+	})
+	if err != nil {
+		writeError(w, req, span, err)
+		return
+	}
+	cs.setGroupModifyIndex(ctx, w, group)
+	cs.events.groupEvent(eventUpdated, group)
 	renderJSON(ctx, w, group)
 }
 
 // swagger:route GET /groups/ group getGroups
-// Get all groups
+// Get all groups, optionally filtered by a label selector
+//
+// Returns one page of at most limit groups, the next page is linked in
+// the Link header.
 //
 // responses:
 //
+//	400: ErrorResponse
 //	200: []ResponseGroup
 func (cs *configServer) getAllGroupsHandler(w http.ResponseWriter, req *http.Request) {
 	span := tracer.StartSpanFromRequest("getAllGroupsHandler", cs.tracer, req)
@@ -363,12 +686,44 @@ func (cs *configServer) getAllGroupsHandler(w http.ResponseWriter, req *http.Req
 	)
 
 	ctx := tracer.ContextWithSpan(context.Background(), span)
-	allTasks, err := cs.store.GetAllGroups(ctx)
+	selector, err := s.ParseSelector(req.URL.Query().Get("selector"))
+	if err != nil {
+		writeError(w, req, span, err)
+		return
+	}
+	expand, err := boolQuery(req, "expand")
+	if err != nil {
+		writeError(w, req, span, err)
+		return
+	}
+	opts, err := pageQuery(req)
+	if err != nil {
+		writeError(w, req, span, err)
+		return
+	}
+	opts.Selector = selector
+	fields, err := fieldsQuery(req, s.Group{})
+	if err != nil {
+		writeError(w, req, span, err)
+		return
+	}
+
+	allTasks, next, err := cs.store.ListGroups(ctx, opts)
+	if err != nil {
+		writeError(w, req, span, err)
+		return
+	}
+	if _, err := cs.resolveGroups(ctx, allTasks, expand); err != nil {
+		writeProblem(w, req, span, http.StatusInternalServerError, err)
+		return
+	}
+	body, err := project(allTasks, fields)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeProblem(w, req, span, http.StatusInternalServerError, err)
 		return
 	}
-	renderJSON(ctx, w, allTasks)
+	setNextPage(w, req, next)
+	renderJSON(ctx, w, body)
 }
 
 // swagger:route GET /group/{id}/ group getGroupById
@@ -377,6 +732,7 @@ func (cs *configServer) getAllGroupsHandler(w http.ResponseWriter, req *http.Req
 // responses:
 //
 //	404: ErrorResponse
+//	304: NoContentResponse
 //	200: ResponseGroup
 func (cs *configServer) getGroupHandler(w http.ResponseWriter, req *http.Request) {
 	span := tracer.StartSpanFromRequest("getGroupHandler", cs.tracer, req)
@@ -387,12 +743,57 @@ func (cs *configServer) getGroupHandler(w http.ResponseWriter, req *http.Request
 	)
 	ctx := tracer.ContextWithSpan(context.Background(), span)
 	id := mux.Vars(req)["id"]
-	version := mux.Vars(req)["version"]
-	task, err := cs.store.GetGroup(ctx, id, version)
+	version, err := cs.store.ResolveGroupVersion(ctx, id, mux.Vars(req)["version"])
+	if err != nil {
+		writeError(w, req, span, err)
+		return
+	}
+
+	watch, index, wait, err := watchQuery(req)
+	if err != nil {
+		writeError(w, req, span, err)
+		return
+	}
+
+	selector, err := s.ParseSelector(req.URL.Query().Get("selector"))
+	if err != nil {
+		writeError(w, req, span, err)
+		return
+	}
+	expand, err := boolQuery(req, "expand")
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeError(w, req, span, err)
+		return
+	}
+
+	var task []*s.Group
+	if watch {
+		task, index, err = cs.store.WaitGroups(tracer.ContextWithSpan(req.Context(), span), id, version, index, wait)
+		w.Header().Set("X-Modify-Index", strconv.FormatUint(index, 10))
+		task = selectGroups(task, selector)
+	} else {
+		task, err = cs.store.GetGroupsBySelector(ctx, id, version, selector)
+	}
+	if err != nil {
+		writeError(w, req, span, err)
+		return
+	}
+	if len(task) == 0 {
+		writeError(w, req, span, groupNotFound(id, version))
+		return
+	}
+	if !watch {
+		setModifyIndex(w, task[0].Index)
+	}
+	tag, err := cs.resolveGroupsTag(ctx, task, expand)
+	if err != nil {
+		writeProblem(w, req, span, http.StatusInternalServerError, err)
+		return
+	}
+	if notModified(w, req, tag) {
 		return
 	}
+	w.Header().Set("ETag", tag)
 	renderJSON(ctx, w, task)
 
 }
@@ -407,11 +808,48 @@ func (cs *configServer) getGroupHandlerId(w http.ResponseWriter, req *http.Reque
 	ctx := tracer.ContextWithSpan(context.Background(), span)
 	id := mux.Vars(req)["id"]
 
-	task, err := cs.store.GetGroupId(ctx, id)
+	watch, index, wait, err := watchQuery(req)
+	if err != nil {
+		writeError(w, req, span, err)
+		return
+	}
+
+	selector, err := s.ParseSelector(req.URL.Query().Get("selector"))
+	if err != nil {
+		writeError(w, req, span, err)
+		return
+	}
+	expand, err := boolQuery(req, "expand")
+	if err != nil {
+		writeError(w, req, span, err)
+		return
+	}
+
+	var task []*s.Group
+	if watch {
+		task, index, err = cs.store.WaitGroups(tracer.ContextWithSpan(req.Context(), span), id, "", index, wait)
+		w.Header().Set("X-Modify-Index", strconv.FormatUint(index, 10))
+		task = selectGroups(task, selector)
+	} else {
+		task, err = cs.store.GetGroupsBySelector(ctx, id, "", selector)
+	}
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeError(w, req, span, err)
 		return
 	}
+	if len(task) == 0 {
+		writeError(w, req, span, groupNotFound(id, ""))
+		return
+	}
+	tag, err := cs.resolveGroupsTag(ctx, task, expand)
+	if err != nil {
+		writeProblem(w, req, span, http.StatusInternalServerError, err)
+		return
+	}
+	if notModified(w, req, tag) {
+		return
+	}
+	w.Header().Set("ETag", tag)
 	renderJSON(ctx, w, task)
 
 }
@@ -422,6 +860,7 @@ func (cs *configServer) getGroupHandlerId(w http.ResponseWriter, req *http.Reque
 // responses:
 //
 //	404: ErrorResponse
+//	412: ErrorResponse
 //	204: NoContentResponse
 //	201: ResponseGroup
 func (cs *configServer) delGroupHandler(w http.ResponseWriter, req *http.Request) {
@@ -436,11 +875,33 @@ func (cs *configServer) delGroupHandler(w http.ResponseWriter, req *http.Request
 	id := mux.Vars(req)["id"]
 	version := mux.Vars(req)["version"]
 
-	msg, err := cs.store.DeleteGroup(ctx, id, version)
+	var msg map[string]string
+	var err error
+	if req.Header.Get("If-Match") != "" {
+		var current []*s.Group
+		if current, err = cs.store.GetGroup(ctx, id, version); err != nil {
+			writeError(w, req, span, err)
+			return
+		}
+		var tag string
+		if tag, err = cs.groupsTag(ctx, current); err != nil {
+			writeProblem(w, req, span, http.StatusInternalServerError, err)
+			return
+		}
+		if preconditionFailed(req, tag) {
+			writeError(w, req, span, errPreconditionFailed)
+			return
+		}
+		msg = map[string]string{"Deleted": id}
+		err = cs.store.DeleteGroups(ctx, current)
+	} else {
+		msg, err = cs.store.DeleteGroup(ctx, id, version)
+	}
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeError(w, req, span, err)
 		return
 	}
+	cs.events.groupEvent(eventDeleted, &s.Group{Id: id, Version: version})
 	renderJSON(ctx, w, msg)
 	/*_, ok := cs.groupData[id]
 	if !ok {
@@ -461,11 +922,33 @@ func (cs *configServer) delGroupHandlerId(w http.ResponseWriter, req *http.Reque
 	ctx := tracer.ContextWithSpan(context.Background(), span)
 	id := mux.Vars(req)["id"]
 
-	msg, err := cs.store.DeleteGroupId(ctx, id)
+	var msg map[string]string
+	var err error
+	if req.Header.Get("If-Match") != "" {
+		var current []*s.Group
+		if current, err = cs.store.GetGroupId(ctx, id); err != nil {
+			writeError(w, req, span, err)
+			return
+		}
+		var tag string
+		if tag, err = cs.groupsTag(ctx, current); err != nil {
+			writeProblem(w, req, span, http.StatusInternalServerError, err)
+			return
+		}
+		if preconditionFailed(req, tag) {
+			writeError(w, req, span, errPreconditionFailed)
+			return
+		}
+		msg = map[string]string{"Deleted": id}
+		err = cs.store.DeleteGroups(ctx, current)
+	} else {
+		msg, err = cs.store.DeleteGroupId(ctx, id)
+	}
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeError(w, req, span, err)
 		return
 	}
+	cs.events.groupEvent(eventDeleted, &s.Group{Id: id})
 	renderJSON(ctx, w, msg)
 	/*_, ok := cs.groupData[id]
 	if !ok {
@@ -482,6 +965,8 @@ func (cs *configServer) delGroupHandlerId(w http.ResponseWriter, req *http.Reque
 // responses:
 //
 //	404: ErrorResponse
+//	409: ErrorResponse
+//	412: ErrorResponse
 //	204: NoContentResponse
 func (cs *configServer) delConfigFromGroupHandler(w http.ResponseWriter, req *http.Request) {
 
@@ -492,37 +977,22 @@ func (cs *configServer) delConfigFromGroupHandler(w http.ResponseWriter, req *ht
 		tracer.LogString("handler", fmt.Sprintf("handling del config from group at %s\n", req.URL.Path)),
 	)
 
-	ctx := tracer.ContextWithSpan(context.Background(), span)
+	ctx := withChange(tracer.ContextWithSpan(context.Background(), span), req)
 	groupId := mux.Vars(req)["groupId"]
 	groupVersion := mux.Vars(req)["g_version"]
-	//configVersion := mux.Vars(req)["c_version"]
 	id := mux.Vars(req)["id"]
-	group, err2 := cs.store.GetOneGroup(ctx, groupId, groupVersion)
-	if err2 != nil {
-		err := errors.New("group not found")
-		http.Error(w, err.Error(), http.StatusNotFound)
-		return
-	}
-	for i, config := range group.Configs {
-		if config.Id == id {
-			group.Configs = append(group.Configs[:i], group.Configs[i+1:]...)
-			//cs.groupData[groupId] = group
-			grupas, err := cs.store.SaveGroup(ctx, group)
-			if err != nil {
-				http.Error(w, err.Error(), http.StatusBadRequest)
-				return
-			}
-			renderJSON(ctx, w, grupas)
-			return
-		}
-	}
-	err := errors.New("config not found in group")
-	http.Error(w, err.Error(), http.StatusNotFound)
-	grupas, err := cs.store.SaveGroup(ctx, group)
+
+	grupas, err := cs.updateGroup(ctx, cs.ifMatch(ctx, req), func() (*s.Group, error) {
+		return cs.store.GetOneGroup(ctx, groupId, groupVersion)
+	}, func(group *s.Group) error {
+		return removeConfigFromGroup(group, id)
+	})
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeError(w, req, span, err)
 		return
 	}
+	cs.setGroupModifyIndex(ctx, w, grupas)
+	cs.events.groupEvent(eventUpdated, grupas)
 	renderJSON(ctx, w, grupas)
 }
 func (cs *configServer) delConfigFromGroupHandler2(w http.ResponseWriter, req *http.Request) {
@@ -534,38 +1004,162 @@ func (cs *configServer) delConfigFromGroupHandler2(w http.ResponseWriter, req *h
 		tracer.LogString("handler", fmt.Sprintf("handling del config from group at %s\n", req.URL.Path)),
 	)
 
-	ctx := tracer.ContextWithSpan(context.Background(), span)
+	ctx := withChange(tracer.ContextWithSpan(context.Background(), span), req)
 	groupId := mux.Vars(req)["groupId"]
-	//groupVersion := mux.Vars(req)["g_version"]
-	//configVersion := mux.Vars(req)["c_version"]
 	id := mux.Vars(req)["id"]
-	group, err2 := cs.store.GetOneGroup2(ctx, groupId)
-	if err2 != nil {
-		err := errors.New("group not found")
-		http.Error(w, err.Error(), http.StatusNotFound)
+
+	grupas, err := cs.updateGroup(ctx, cs.ifMatch(ctx, req), func() (*s.Group, error) {
+		return cs.store.GetOneGroup2(ctx, groupId)
+	}, func(group *s.Group) error {
+		return removeConfigFromGroup(group, id)
+	})
+	if err != nil {
+		writeError(w, req, span, err)
 		return
 	}
+	cs.setGroupModifyIndex(ctx, w, grupas)
+	cs.events.groupEvent(eventUpdated, grupas)
+	renderJSON(ctx, w, grupas)
+}
+
+// updateGroup loads the group, applies change and saves it. When the group
+// was modified in the meantime it starts over with a fresh copy, so
+// concurrent updates are not lost. After groupUpdateRetries attempts it
+// gives up with store.ErrConflict. A non-nil precondition is checked
+// against every loaded copy, see ifMatch and ifIndex.
+func (cs *configServer) updateGroup(ctx context.Context, precondition func(*s.Group) error, load func() (*s.Group, error), change func(*s.Group) error) (*s.Group, error) {
+	for i := 0; i < groupUpdateRetries; i++ {
+		group, err := load()
+		if err != nil {
+			return nil, err
+		}
+		if precondition != nil {
+			if err := precondition(group); err != nil {
+				return nil, err
+			}
+		}
+		if err := change(group); err != nil {
+			return nil, err
+		}
+
+		saved, err := cs.store.SaveGroup(ctx, group)
+		if errors.Is(err, s.ErrConflict) {
+			continue
+		}
+		return saved, err
+	}
+	return nil, s.ErrConflict
+}
+
+// selectConfigs drops the configs whose labels do not match selector.
+func selectConfigs(configs []*s.Config, selector s.Selector) []*s.Config {
+	selected := []*s.Config{}
+	for _, config := range configs {
+		if selector.Matches(config.Labels) {
+			selected = append(selected, config)
+		}
+	}
+	return selected
+}
+
+// selectGroups drops the groups whose labels do not match selector.
+func selectGroups(groups []*s.Group, selector s.Selector) []*s.Group {
+	selected := []*s.Group{}
+	for _, group := range groups {
+		if selector.Matches(group.Labels) {
+			selected = append(selected, group)
+		}
+	}
+	return selected
+}
+
+// addGroupMember adds config to group, as a copy or, in reference mode,
+// as a reference to version, which may be a range like ^1.2.
+func addGroupMember(group *s.Group, config *s.Config, version string) {
+	if group.IsReference() {
+		group.Refs = append(group.Refs, s.ConfigRef{Id: config.Id, Version: version, Labels: config.Labels})
+		return
+	}
+	group.Configs = append(group.Configs, *config)
+}
+
+func removeConfigFromGroup(group *s.Group, id string) error {
+	if group.IsReference() {
+		for i, ref := range group.Refs {
+			if ref.Id == id {
+				group.Refs = append(group.Refs[:i], group.Refs[i+1:]...)
+				return nil
+			}
+		}
+		return errConfigNotInGroup
+	}
 	for i, config := range group.Configs {
 		if config.Id == id {
 			group.Configs = append(group.Configs[:i], group.Configs[i+1:]...)
-			//cs.groupData[groupId] = group
-			grupas, err := cs.store.SaveGroup(ctx, group)
-			if err != nil {
-				http.Error(w, err.Error(), http.StatusBadRequest)
-				return
-			}
-			renderJSON(ctx, w, grupas)
-			return
+			return nil
 		}
 	}
-	err := errors.New("config not found in group")
-	http.Error(w, err.Error(), http.StatusNotFound)
-	grupas, err := cs.store.SaveGroup(ctx, group)
+	return errConfigNotInGroup
+}
+
+// swagger:route GET /config/{id}/versions/ config getConfigVersions
+// List all versions of a config, lowest first
+//
+// responses:
+//
+//	404: ErrorResponse
+//	200: VersionsResponse
+func (cs *configServer) getConfigVersionsHandler(w http.ResponseWriter, req *http.Request) {
+	span := tracer.StartSpanFromRequest("getConfigVersionsHandler", cs.tracer, req)
+	defer span.Finish()
+
+	span.LogFields(
+		tracer.LogString("handler", fmt.Sprintf("handling get config versions at %s\n", req.URL.Path)),
+	)
+
+	ctx := tracer.ContextWithSpan(context.Background(), span)
+	id := mux.Vars(req)["id"]
+
+	versions, err := cs.store.ConfigVersions(ctx, id)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeError(w, req, span, err)
 		return
 	}
-	renderJSON(ctx, w, grupas)
+	if len(versions) == 0 {
+		writeError(w, req, span, s.ErrConfigNotFound)
+		return
+	}
+	renderJSON(ctx, w, versions)
+}
+
+// swagger:route GET /group/{id}/versions/ group getGroupVersions
+// List all versions of a group, lowest first
+//
+// responses:
+//
+//	404: ErrorResponse
+//	200: VersionsResponse
+func (cs *configServer) getGroupVersionsHandler(w http.ResponseWriter, req *http.Request) {
+	span := tracer.StartSpanFromRequest("getGroupVersionsHandler", cs.tracer, req)
+	defer span.Finish()
+
+	span.LogFields(
+		tracer.LogString("handler", fmt.Sprintf("handling get group versions at %s\n", req.URL.Path)),
+	)
+
+	ctx := tracer.ContextWithSpan(context.Background(), span)
+	id := mux.Vars(req)["id"]
+
+	versions, err := cs.store.GroupVersions(ctx, id)
+	if err != nil {
+		writeError(w, req, span, err)
+		return
+	}
+	if len(versions) == 0 {
+		writeError(w, req, span, s.ErrGroupNotFound)
+		return
+	}
+	renderJSON(ctx, w, versions)
 }
 
 func (ts *configServer) swaggerHandler(w http.ResponseWriter, r *http.Request) {
@@ -583,18 +1177,38 @@ func (s *configServer) getPostByLabel(w http.ResponseWriter, req *http.Request)
 	ctx := tracer.ContextWithSpan(context.Background(), span)
 
 	id := mux.Vars(req)["id"]
-	version := mux.Vars(req)["version"]
 	labels := mux.Vars(req)["labels"]
+	version, err := s.store.ResolveConfigVersion(ctx, id, mux.Vars(req)["version"])
+	if err != nil {
+		writeError(w, req, span, err)
+		return
+	}
 
 	task, err := s.store.GetConfigsByLabels(ctx, id, version, labels)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeError(w, req, span, err)
+		return
+	}
+	if len(task) == 0 {
+		writeError(w, req, span, configNotFound(id, version))
+		return
+	}
+	tag := configsETag(task)
+	if notModified(w, req, tag) {
 		return
 	}
+	w.Header().Set("ETag", tag)
 	renderJSON(ctx, w, task)
 }
 
-/*
+// swagger:route GET /group/{id}/{version}/{labels}/ group getGroupsByLabels
+// Get group by labels
+//
+// responses:
+//
+//	400: ErrorResponse
+//	404: ErrorResponse
+//	200: []ResponseGroup
 func (s *configServer) getGroupsByLabel(w http.ResponseWriter, req *http.Request) {
 	span := tracer.StartSpanFromRequest("getGroupsByLabelHandler", s.tracer, req)
 	defer span.Finish()
@@ -605,13 +1219,80 @@ func (s *configServer) getGroupsByLabel(w http.ResponseWriter, req *http.Request
 
 	ctx := tracer.ContextWithSpan(context.Background(), span)
 	id := mux.Vars(req)["id"]
-	version := mux.Vars(req)["version"]
 	labels := mux.Vars(req)["labels"]
+	version, err := s.store.ResolveGroupVersion(ctx, id, mux.Vars(req)["version"])
+	if err != nil {
+		writeError(w, req, span, err)
+		return
+	}
+
+	expand, err := boolQuery(req, "expand")
+	if err != nil {
+		writeError(w, req, span, err)
+		return
+	}
 
 	task, err := s.store.GetGroupsByLabels(ctx, id, version, labels)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeError(w, req, span, err)
+		return
+	}
+	if len(task) == 0 {
+		writeError(w, req, span, groupNotFound(id, version))
+		return
+	}
+	if _, err := s.resolveGroups(ctx, task, expand); err != nil {
+		writeProblem(w, req, span, http.StatusInternalServerError, err)
 		return
 	}
 	renderJSON(ctx, w, task)
-}*/
+}
+
+// swagger:route DELETE /group/{id}/{version}/{labels}/ group deleteGroupByLabels
+// Delete group if it has exactly these labels
+//
+// responses:
+//
+//	400: ErrorResponse
+//	412: ErrorResponse
+//	201: ResponseGroup
+func (cs *configServer) delGroupByLabelHandler(w http.ResponseWriter, req *http.Request) {
+	span := tracer.StartSpanFromRequest("delGroupByLabelHandler", cs.tracer, req)
+	defer span.Finish()
+
+	span.LogFields(
+		tracer.LogString("handler", fmt.Sprintf("handling delete group by label at %s\n", req.URL.Path)),
+	)
+
+	ctx := tracer.ContextWithSpan(context.Background(), span)
+	id := mux.Vars(req)["id"]
+	version := mux.Vars(req)["version"]
+	label := mux.Vars(req)["labels"]
+
+	current, err := cs.store.GetGroupsByLabels(ctx, id, version, label)
+	if err != nil {
+		writeError(w, req, span, err)
+		return
+	}
+	if req.Header.Get("If-Match") != "" {
+		tag, err := cs.groupsTag(ctx, current)
+		if err != nil {
+			writeProblem(w, req, span, http.StatusInternalServerError, err)
+			return
+		}
+		if preconditionFailed(req, tag) {
+			writeError(w, req, span, errPreconditionFailed)
+			return
+		}
+	}
+
+	if err := cs.store.DeleteGroups(ctx, current); err != nil {
+		writeError(w, req, span, err)
+		return
+	}
+	msg := map[string]string{"Deleted": id}
+	for _, group := range current {
+		cs.events.groupEvent(eventDeleted, group)
+	}
+	renderJSON(ctx, w, msg)
+}
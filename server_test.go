@@ -0,0 +1,332 @@
+package main
+
+import (
+	"encoding/json"
+	s "example.com/mod/store"
+	"github.com/opentracing/opentracing-go"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestServer serves the REST routes over a memory store.
+func newTestServer(t *testing.T) (*configServer, *httptest.Server) {
+	t.Helper()
+	cs := &configServer{
+		store:          s.NewMemory(),
+		events:         newEventBroker(),
+		tracer:         opentracing.NoopTracer{},
+		idempotencyTTL: time.Hour,
+	}
+	ts := httptest.NewServer(newRouter(cs))
+	t.Cleanup(ts.Close)
+	return cs, ts
+}
+
+// do sends a request with a JSON body, if any, and the given headers and
+// returns the response with its body read.
+func do(t *testing.T, method string, url string, body string, header map[string]string) (*http.Response, string) {
+	t.Helper()
+	var reader io.Reader
+	if body != "" {
+		reader = strings.NewReader(body)
+	}
+	req, err := http.NewRequest(method, url, reader)
+	if err != nil {
+		t.Fatalf("NewRequest failed: %v", err)
+	}
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	for key, value := range header {
+		req.Header.Set(key, value)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("%s %s failed: %v", method, url, err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("reading %s %s failed: %v", method, url, err)
+	}
+	return resp, string(data)
+}
+
+// createConfig posts body to /config/ and returns the created config.
+func createConfig(t *testing.T, ts *httptest.Server, body string) *s.Config {
+	t.Helper()
+	resp, data := do(t, http.MethodPost, ts.URL+"/config/", body, nil)
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("POST /config/ returned %d: %s", resp.StatusCode, data)
+	}
+	config := &s.Config{}
+	if err := json.Unmarshal([]byte(data), config); err != nil {
+		t.Fatalf("decoding config failed: %v", err)
+	}
+	return config
+}
+
+// createGroup posts body to /group/ and returns the created group.
+func createGroup(t *testing.T, ts *httptest.Server, body string) *s.Group {
+	t.Helper()
+	resp, data := do(t, http.MethodPost, ts.URL+"/group/", body, nil)
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("POST /group/ returned %d: %s", resp.StatusCode, data)
+	}
+	group := &s.Group{}
+	if err := json.Unmarshal([]byte(data), group); err != nil {
+		t.Fatalf("decoding group failed: %v", err)
+	}
+	return group
+}
+
+// checkProblem fails t unless resp is a problem document with status and
+// code.
+func checkProblem(t *testing.T, resp *http.Response, data string, status int, code string) {
+	t.Helper()
+	problem := &Problem{}
+	if err := json.Unmarshal([]byte(data), problem); err != nil {
+		t.Errorf("decoding problem %q failed: %v", data, err)
+		return
+	}
+	if resp.StatusCode != status || resp.Header.Get("Content-Type") != problemContentType ||
+		problem.Status != status || problem.Code != code || problem.Instance != resp.Request.URL.Path {
+		t.Errorf("got %d %s %+v, want %d with code %s", resp.StatusCode, resp.Header.Get("Content-Type"), problem, status, code)
+	}
+}
+
+func TestDeleteConfigByLabels(t *testing.T) {
+	_, ts := newTestServer(t)
+	config := createConfig(t, ts, `{"version":"1.0.0","labels":{"env":"prod","region":"eu"},"entries":{"a":"1"}}`)
+	url := ts.URL + "/config/" + config.Id + "/1.0.0/"
+
+	resp, _ := do(t, http.MethodGet, url+"region=eu,env=prod/", "", nil)
+	tag := resp.Header.Get("ETag")
+	if resp.StatusCode != http.StatusOK || tag == "" {
+		t.Fatalf("GET by labels returned %d with ETag %q", resp.StatusCode, tag)
+	}
+
+	// env=pr and env=prod are prefixes of the stored labels, not a match.
+	for _, labels := range []string{"env=pr", "env=prod"} {
+		if resp, _ = do(t, http.MethodGet, url+labels+"/", "", nil); resp.StatusCode != http.StatusNotFound {
+			t.Errorf("GET %s returned %d", labels, resp.StatusCode)
+		}
+		if resp, _ = do(t, http.MethodDelete, url+labels+"/", "", nil); resp.StatusCode != http.StatusNotFound {
+			t.Errorf("DELETE %s returned %d", labels, resp.StatusCode)
+		}
+		if resp, _ = do(t, http.MethodDelete, url+labels+"/", "", map[string]string{"If-Match": tag}); resp.StatusCode != http.StatusNotFound {
+			t.Errorf("DELETE %s with If-Match returned %d", labels, resp.StatusCode)
+		}
+	}
+	if resp, _ = do(t, http.MethodGet, url, "", nil); resp.StatusCode != http.StatusOK {
+		t.Fatalf("config is gone after deleting other labels: %d", resp.StatusCode)
+	}
+
+	if resp, _ = do(t, http.MethodDelete, url+"env=prod,region=eu/", "", map[string]string{"If-Match": `"0"`}); resp.StatusCode != http.StatusPreconditionFailed {
+		t.Errorf("DELETE with a stale If-Match returned %d", resp.StatusCode)
+	}
+	if resp, _ = do(t, http.MethodDelete, url+"env=prod,region=eu/", "", map[string]string{"If-Match": tag}); resp.StatusCode != http.StatusOK {
+		t.Errorf("DELETE with If-Match returned %d", resp.StatusCode)
+	}
+	if resp, _ = do(t, http.MethodGet, url, "", nil); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("GET after DELETE returned %d", resp.StatusCode)
+	}
+}
+
+func TestConfigConditionalRequests(t *testing.T) {
+	_, ts := newTestServer(t)
+	config := createConfig(t, ts, `{"version":"1.0.0","entries":{"a":"1"}}`)
+	url := ts.URL + "/config/" + config.Id + "/1.0.0/"
+
+	resp, _ := do(t, http.MethodGet, url, "", nil)
+	tag := resp.Header.Get("ETag")
+	if resp.StatusCode != http.StatusOK || tag == "" {
+		t.Fatalf("GET returned %d with ETag %q", resp.StatusCode, tag)
+	}
+
+	resp, data := do(t, http.MethodGet, url, "", map[string]string{"If-None-Match": tag})
+	if resp.StatusCode != http.StatusNotModified || data != "" {
+		t.Errorf("GET with If-None-Match returned %d: %q", resp.StatusCode, data)
+	}
+	if resp, _ = do(t, http.MethodGet, url, "", map[string]string{"If-None-Match": `"0"`}); resp.StatusCode != http.StatusOK {
+		t.Errorf("GET with another If-None-Match returned %d", resp.StatusCode)
+	}
+
+	if resp, _ = do(t, http.MethodDelete, url, "", map[string]string{"If-Match": `"0"`}); resp.StatusCode != http.StatusPreconditionFailed {
+		t.Errorf("DELETE with a stale If-Match returned %d", resp.StatusCode)
+	}
+	if resp, _ = do(t, http.MethodGet, url, "", nil); resp.StatusCode != http.StatusOK {
+		t.Fatalf("config is gone after a failed DELETE: %d", resp.StatusCode)
+	}
+	if resp, _ = do(t, http.MethodDelete, url, "", map[string]string{"If-Match": tag}); resp.StatusCode != http.StatusOK {
+		t.Errorf("DELETE with If-Match returned %d", resp.StatusCode)
+	}
+	if resp, _ = do(t, http.MethodGet, url, "", nil); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("GET after DELETE returned %d", resp.StatusCode)
+	}
+}
+
+func TestMemoryStoreServesAPI(t *testing.T) {
+	_, ts := newTestServer(t)
+	config := createConfig(t, ts, `{"version":"1.0.0","labels":{"env":"prod"},"entries":{"a":"1"}}`)
+	resp, data := do(t, http.MethodPost, ts.URL+"/config/"+config.Id+"/", `{"version":"1.1.0","entries":{"a":"2"}}`, nil)
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("POST /config/%s/ returned %d: %s", config.Id, resp.StatusCode, data)
+	}
+	createConfig(t, ts, `{"version":"2.0.0","entries":{"b":"1"}}`)
+
+	resp, data = do(t, http.MethodGet, ts.URL+"/config/"+config.Id+"/1.0.0/", "", nil)
+	configs := []*s.Config{}
+	if err := json.Unmarshal([]byte(data), &configs); resp.StatusCode != http.StatusOK || err != nil {
+		t.Fatalf("GET config returned %d: %s", resp.StatusCode, data)
+	}
+	if len(configs) != 1 || configs[0].Entries["a"] != "1" || configs[0].Labels["env"] != "prod" {
+		t.Errorf("GET config returned %v", configs)
+	}
+
+	resp, data = do(t, http.MethodGet, ts.URL+"/config/"+config.Id+"/versions/", "", nil)
+	versions := []string{}
+	if err := json.Unmarshal([]byte(data), &versions); err != nil || !reflect.DeepEqual(versions, []string{"1.0.0", "1.1.0"}) {
+		t.Errorf("GET versions returned %d: %s", resp.StatusCode, data)
+	}
+
+	// The memory store lists by prefix like Consul, in pages.
+	url, listed := ts.URL+"/configs/?limit=2", 0
+	for url != "" {
+		resp, data = do(t, http.MethodGet, url, "", nil)
+		page := []*s.Config{}
+		if err := json.Unmarshal([]byte(data), &page); resp.StatusCode != http.StatusOK || err != nil || len(page) > 2 {
+			t.Fatalf("GET %s returned %d: %s", url, resp.StatusCode, data)
+		}
+		listed += len(page)
+		url = ""
+		if next := resp.Header.Get(nextCursorHeader); next != "" {
+			url = ts.URL + "/configs/?limit=2&cursor=" + next
+		}
+	}
+	if listed != 3 {
+		t.Errorf("listed %d configs, want 3", listed)
+	}
+
+	group := createGroup(t, ts, `{"version":"1.0.0","configs":[{"id":"`+config.Id+`","version":"1.0.0","entries":{"a":"1"}}]}`)
+	resp, data = do(t, http.MethodGet, ts.URL+"/group/"+group.Id+"/1.0.0/", "", nil)
+	groups := []*s.Group{}
+	if err := json.Unmarshal([]byte(data), &groups); resp.StatusCode != http.StatusOK || err != nil || len(groups) != 1 || len(groups[0].Configs) != 1 {
+		t.Errorf("GET group returned %d: %s", resp.StatusCode, data)
+	}
+	if resp, data = do(t, http.MethodGet, ts.URL+"/groups/", "", nil); resp.StatusCode != http.StatusOK {
+		t.Errorf("GET /groups/ returned %d: %s", resp.StatusCode, data)
+	}
+
+	if resp, data = do(t, http.MethodDelete, ts.URL+"/config/"+config.Id+"/1.1.0/", "", nil); resp.StatusCode != http.StatusOK {
+		t.Errorf("DELETE config returned %d: %s", resp.StatusCode, data)
+	}
+	if resp, _ = do(t, http.MethodGet, ts.URL+"/config/"+config.Id+"/1.1.0/", "", nil); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("GET after DELETE returned %d", resp.StatusCode)
+	}
+}
+
+func TestReplaceGroupConfigs(t *testing.T) {
+	_, ts := newTestServer(t)
+	first := createConfig(t, ts, `{"version":"1.0.0","entries":{"a":"1"}}`)
+	second := createConfig(t, ts, `{"version":"1.0.0","entries":{"b":"1"}}`)
+	if resp, data := do(t, http.MethodPost, ts.URL+"/config/"+second.Id+"/", `{"version":"1.1.0","entries":{"b":"2"}}`, nil); resp.StatusCode != http.StatusCreated {
+		t.Fatalf("POST /config/%s/ returned %d: %s", second.Id, resp.StatusCode, data)
+	}
+	group := createGroup(t, ts, `{"version":"1.0.0","configs":[{"id":"`+first.Id+`","version":"1.0.0","entries":{"a":"1"}}]}`)
+	url := ts.URL + "/group/" + group.Id + "/1.0.0/"
+
+	resp, data := do(t, http.MethodPut, url, `{"configs":[{"id":"`+second.Id+`","version":"^1"}]}`, nil)
+	replaced := &s.Group{}
+	if err := json.Unmarshal([]byte(data), replaced); resp.StatusCode != http.StatusOK || err != nil {
+		t.Fatalf("PUT returned %d: %s", resp.StatusCode, data)
+	}
+	if resp.Header.Get("Content-Type") != "application/json" || resp.Header.Get("X-Modify-Index") == "" {
+		t.Errorf("PUT returned headers %v", resp.Header)
+	}
+	if len(replaced.Configs) != 1 || replaced.Configs[0].Id != second.Id || replaced.Configs[0].Version != "1.1.0" {
+		t.Errorf("PUT returned configs %v, want %s 1.1.0 only", replaced.Configs, second.Id)
+	}
+
+	// A missing or repeated reference leaves the group as it is.
+	resp, data = do(t, http.MethodPut, url, `{"configs":[{"id":"`+first.Id+`","version":"1.0.0"},{"id":"missing","version":"1.0.0"}]}`, nil)
+	checkProblem(t, resp, data, http.StatusNotFound, "config_not_found")
+	resp, data = do(t, http.MethodPut, url, `{"configs":[{"id":"`+first.Id+`","version":"1.0.0"},{"id":"`+first.Id+`","version":"1.0.0"}]}`, nil)
+	checkProblem(t, resp, data, http.StatusBadRequest, "duplicate_member")
+	resp, data = do(t, http.MethodPut, url, `{"configs":[]}`, map[string]string{"If-Match": `"0"`})
+	checkProblem(t, resp, data, http.StatusPreconditionFailed, "precondition_failed")
+	resp, data = do(t, http.MethodPut, url, `{"configs":[]}`, map[string]string{"Content-Type": "text/plain"})
+	checkProblem(t, resp, data, http.StatusUnsupportedMediaType, "unsupported_media_type")
+	resp, data = do(t, http.MethodPut, ts.URL+"/group/missing/1.0.0/", `{"configs":[]}`, nil)
+	checkProblem(t, resp, data, http.StatusNotFound, "group_not_found")
+
+	resp, data = do(t, http.MethodGet, url, "", nil)
+	groups := []*s.Group{}
+	if err := json.Unmarshal([]byte(data), &groups); err != nil || len(groups) != 1 || len(groups[0].Configs) != 1 || groups[0].Configs[0].Id != second.Id {
+		t.Errorf("GET after failed replaces returned %d: %s", resp.StatusCode, data)
+	}
+
+	// PUT of one member adds it next to the others.
+	resp, data = do(t, http.MethodPut, ts.URL+"/group/"+group.Id+"/1.0.0/config/"+first.Id+"/1.0.0/", "", nil)
+	added := &s.Group{}
+	if err := json.Unmarshal([]byte(data), added); resp.StatusCode != http.StatusOK || err != nil || len(added.Configs) != 2 {
+		t.Errorf("adding a config returned %d: %s", resp.StatusCode, data)
+	}
+	resp, data = do(t, http.MethodPut, url, `{"configs":[]}`, nil)
+	if err := json.Unmarshal([]byte(data), replaced); resp.StatusCode != http.StatusOK || err != nil || len(replaced.Configs) != 0 {
+		t.Errorf("emptying the group returned %d: %s", resp.StatusCode, data)
+	}
+}
+
+func TestCreateResponses(t *testing.T) {
+	_, ts := newTestServer(t)
+	config := createConfig(t, ts, `{"version":"1.0.0"}`)
+	group := createGroup(t, ts, `{"version":"1.0.0"}`)
+
+	for _, c := range []struct {
+		url, body, location string
+	}{
+		{"/config/", `{"version":"1.0.0","entries":{"a":"1"}}`, "/config/*/1.0.0/"},
+		{"/config/" + config.Id + "/", `{"version":"1.1.0"}`, "/config/" + config.Id + "/1.1.0/"},
+		{"/group/", `{"version":"1.0.0"}`, "/group/*/1.0.0/"},
+		{"/group/" + group.Id + "/", `{"version":"1.1.0"}`, "/group/" + group.Id + "/1.1.0/"},
+	} {
+		header := map[string]string{idempotencyKeyHeader: "key-" + c.url}
+		resp, data := do(t, http.MethodPost, ts.URL+c.url, c.body, header)
+		if resp.StatusCode != http.StatusCreated || resp.Header.Get("Content-Type") != "application/json" {
+			t.Fatalf("POST %s returned %d %s: %s", c.url, resp.StatusCode, resp.Header.Get("Content-Type"), data)
+		}
+		if got := resp.Header.Get(idempotencyKeyHeader); got != header[idempotencyKeyHeader] {
+			t.Errorf("POST %s echoed idempotency key %q", c.url, got)
+		}
+
+		// The body is one document, the created resource.
+		dec := json.NewDecoder(strings.NewReader(data))
+		created := struct{ Id, Version string }{}
+		if err := dec.Decode(&created); err != nil || dec.More() {
+			t.Errorf("POST %s returned %q, want a single document", c.url, data)
+		}
+		location := resp.Header.Get("Location")
+		if got := strings.Replace(c.location, "*", created.Id, 1); location != got {
+			t.Errorf("POST %s returned Location %q, want %q", c.url, location, got)
+		}
+		if resp, data := do(t, http.MethodGet, ts.URL+location, "", nil); resp.StatusCode != http.StatusOK {
+			t.Errorf("GET %s returned %d: %s", location, resp.StatusCode, data)
+		}
+
+		replay, _ := do(t, http.MethodPost, ts.URL+c.url, c.body, header)
+		if replay.StatusCode != http.StatusCreated || replay.Header.Get("Location") != location || replay.Header.Get("Idempotent-Replayed") != "true" {
+			t.Errorf("replayed POST %s returned %d at %q", c.url, replay.StatusCode, replay.Header.Get("Location"))
+		}
+	}
+
+	resp, data := do(t, http.MethodGet, ts.URL+"/config/"+config.Id+"/2.0.0/", "", nil)
+	checkProblem(t, resp, data, http.StatusNotFound, "config_not_found")
+	resp, data = do(t, http.MethodGet, ts.URL+"/group/missing/", "", nil)
+	checkProblem(t, resp, data, http.StatusNotFound, "group_not_found")
+}
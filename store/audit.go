@@ -0,0 +1,83 @@
+package store
+
+import (
+	"context"
+	"time"
+)
+
+// Audit records when and by whom a config or group version was created
+// and last changed. The store stamps it on every write, values sent by
+// clients are ignored.
+//
+// swagger:model Audit
+type Audit struct {
+	// When the version was published
+	// in: time
+	CreatedAt *time.Time `json:"createdAt,omitempty"`
+
+	// Who published the version
+	// in: string
+	CreatedBy string `json:"createdBy,omitempty"`
+
+	// When the version was last written, the same as createdAt until it
+	// changes
+	// in: time
+	UpdatedAt *time.Time `json:"updatedAt,omitempty"`
+
+	// Who wrote the version last
+	// in: string
+	UpdatedBy string `json:"updatedBy,omitempty"`
+
+	// Why the version was last written
+	// in: string
+	ChangeDescription string `json:"changeDescription,omitempty"`
+}
+
+// UpdatedSince reports whether the version was written at or after t.
+// Versions stored before audits were recorded never were.
+func (a Audit) UpdatedSince(t time.Time) bool {
+	return a.UpdatedAt != nil && !a.UpdatedAt.Before(t)
+}
+
+// Change is who makes a write and why, passed to the store with
+// WithChange.
+type Change struct {
+	Author      string
+	Description string
+}
+
+type changeKey struct{}
+
+// WithChange attaches change to ctx, writes made with ctx are recorded as
+// made by change.Author.
+func WithChange(ctx context.Context, change Change) context.Context {
+	return context.WithValue(ctx, changeKey{}, change)
+}
+
+func changeFrom(ctx context.Context) Change {
+	change, _ := ctx.Value(changeKey{}).(Change)
+	return change
+}
+
+// createdAudit is the audit of a version written for the first time.
+func createdAudit(ctx context.Context) Audit {
+	now := time.Now().UTC()
+	change := changeFrom(ctx)
+	return Audit{
+		CreatedAt:         &now,
+		CreatedBy:         change.Author,
+		UpdatedAt:         &now,
+		UpdatedBy:         change.Author,
+		ChangeDescription: change.Description,
+	}
+}
+
+// updatedAudit keeps the creation of a and records the change in ctx.
+func (a Audit) updatedAudit(ctx context.Context) Audit {
+	now := time.Now().UTC()
+	change := changeFrom(ctx)
+	a.UpdatedAt = &now
+	a.UpdatedBy = change.Author
+	a.ChangeDescription = change.Description
+	return a
+}
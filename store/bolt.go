@@ -0,0 +1,379 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	bolt "go.etcd.io/bbolt"
+	"os"
+	"sync"
+	"time"
+)
+
+var (
+	boltBucket          = []byte("kv")
+	boltIndexBucket     = []byte("index")
+	boltTombstoneBucket = []byte("tombstones")
+	boltCreatedBucket   = []byte("created")
+)
+
+// boltKV keeps every pair in a single bbolt bucket. Keys are stored as-is,
+// so bbolt's byte ordering gives the same prefix listing as Consul. The
+// modify index of each key lives under the same key in a second bucket,
+// the index and time deleted keys were removed at in a third one and the
+// index keys were created at in a fourth one. Tombstones are dropped after
+// tombstoneTTL.
+type boltKV struct {
+	db *bolt.DB
+
+	// changed is closed and replaced after every write. bbolt locks the
+	// file, so this process sees all writes.
+	mu      sync.Mutex
+	changed chan struct{}
+}
+
+// NewBolt returns a Store persisted in the bbolt file at path. When path
+// is empty STORE_PATH is used, falling back to alati.db.
+func NewBolt(path string) (Store, error) {
+	if path == "" {
+		path = os.Getenv("STORE_PATH")
+	}
+	if path == "" {
+		path = "alati.db"
+	}
+
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{boltBucket, boltIndexBucket, boltTombstoneBucket, boltCreatedBucket} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	ps, err := openKVStore(&boltKV{db: db, changed: make(chan struct{})})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return ps, nil
+}
+
+func (b *boltKV) Get(ctx context.Context, key string) (*kvPair, error) {
+	var pair *kvPair
+	err := b.db.View(func(tx *bolt.Tx) error {
+		value := tx.Bucket(boltBucket).Get([]byte(key))
+		if value != nil {
+			pair = boltPair(tx, []byte(key), value)
+		}
+		return nil
+	})
+	return pair, err
+}
+
+func (b *boltKV) List(ctx context.Context, prefix string) ([]*kvPair, error) {
+	pairs := []*kvPair{}
+	err := b.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(boltBucket).Cursor()
+		p := []byte(prefix)
+		for k, v := c.Seek(p); k != nil && bytes.HasPrefix(k, p); k, v = c.Next() {
+			pairs = append(pairs, boltPair(tx, k, v))
+		}
+		return nil
+	})
+	return pairs, err
+}
+
+func (b *boltKV) Keys(ctx context.Context, prefix string) ([]string, error) {
+	keys := []string{}
+	err := b.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(boltBucket).Cursor()
+		p := []byte(prefix)
+		for k, _ := c.Seek(p); k != nil && bytes.HasPrefix(k, p); k, _ = c.Next() {
+			keys = append(keys, string(k))
+		}
+		return nil
+	})
+	return keys, err
+}
+
+func (b *boltKV) GetMany(ctx context.Context, keys []string) ([]*kvPair, error) {
+	pairs := make([]*kvPair, 0, len(keys))
+	err := b.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(boltBucket)
+		for _, key := range keys {
+			if value := bucket.Get([]byte(key)); value != nil {
+				pairs = append(pairs, boltPair(tx, []byte(key), value))
+			}
+		}
+		return nil
+	})
+	return pairs, err
+}
+
+// GetIndexes leaves out the values, bbolt reads them from the mapped file
+// only when they are copied.
+func (b *boltKV) GetIndexes(ctx context.Context, keys []string) ([]*kvPair, error) {
+	pairs := make([]*kvPair, 0, len(keys))
+	err := b.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(boltBucket)
+		for _, key := range keys {
+			if bucket.Get([]byte(key)) != nil {
+				pairs = append(pairs, boltPair(tx, []byte(key), nil))
+			}
+		}
+		return nil
+	})
+	return pairs, err
+}
+
+func (b *boltKV) ListWait(ctx context.Context, prefix string, index uint64, wait time.Duration) ([]*kvPair, uint64, error) {
+	timeout := time.NewTimer(wait)
+	defer timeout.Stop()
+
+	for {
+		b.mu.Lock()
+		changed := b.changed
+		b.mu.Unlock()
+
+		pairs := []*kvPair{}
+		var current uint64
+		err := b.db.View(func(tx *bolt.Tx) error {
+			c := tx.Bucket(boltBucket).Cursor()
+			p := []byte(prefix)
+			for k, v := c.Seek(p); k != nil && bytes.HasPrefix(k, p); k, v = c.Next() {
+				pair := boltPair(tx, k, v)
+				if pair.Index > current {
+					current = pair.Index
+				}
+				pairs = append(pairs, pair)
+			}
+			c = tx.Bucket(boltTombstoneBucket).Cursor()
+			for k, v := c.Seek(p); k != nil && bytes.HasPrefix(k, p); k, v = c.Next() {
+				if deleted := binary.BigEndian.Uint64(v[:8]); deleted > current {
+					current = deleted
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, 0, err
+		}
+
+		if current > index {
+			return pairs, current, nil
+		}
+		select {
+		case <-changed:
+		case <-timeout.C:
+			return pairs, current, nil
+		case <-ctx.Done():
+			return nil, 0, ctx.Err()
+		}
+	}
+}
+
+func (b *boltKV) Put(ctx context.Context, p *kvPair) error {
+	return b.update(func(tx *bolt.Tx) error {
+		return boltPut(tx, p)
+	})
+}
+
+func (b *boltKV) DeleteTree(ctx context.Context, prefix string) error {
+	return b.update(func(tx *bolt.Tx) error {
+		return boltDeleteTree(tx, prefix)
+	})
+}
+
+func (b *boltKV) Txn(ctx context.Context, ops []*kvOp) (bool, error) {
+	ok := true
+	err := b.update(func(tx *bolt.Tx) error {
+		for _, op := range ops {
+			if op.Verb == kvCheckIndex && boltIndex(tx, []byte(op.Pair.Key)) != op.Pair.Index {
+				ok = false
+				return nil
+			}
+		}
+
+		for _, op := range ops {
+			var err error
+			switch op.Verb {
+			case kvSet:
+				err = boltPut(tx, op.Pair)
+			case kvDeleteTree:
+				err = boltDeleteTree(tx, op.Pair.Key)
+			case kvDelete:
+				err = boltDelete(tx, op.Pair.Key)
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return ok, err
+}
+
+func (b *boltKV) Close() error {
+	return b.db.Close()
+}
+
+// update runs fn in a write transaction and wakes up blocked lists.
+func (b *boltKV) update(fn func(tx *bolt.Tx) error) error {
+	if err := b.db.Update(fn); err != nil {
+		return err
+	}
+
+	b.mu.Lock()
+	close(b.changed)
+	b.changed = make(chan struct{})
+	b.mu.Unlock()
+	return nil
+}
+
+func boltPut(tx *bolt.Tx, p *kvPair) error {
+	bucket := tx.Bucket(boltBucket)
+	index, err := bucket.NextSequence()
+	if err != nil {
+		return err
+	}
+
+	value := p.Value
+	if value == nil {
+		// bbolt treats a nil value as missing, Consul stores it as empty.
+		value = []byte{}
+	}
+	if err := bucket.Put([]byte(p.Key), value); err != nil {
+		return err
+	}
+
+	encoded := make([]byte, 8)
+	binary.BigEndian.PutUint64(encoded, index)
+	if err := tx.Bucket(boltIndexBucket).Put([]byte(p.Key), encoded); err != nil {
+		return err
+	}
+	created := tx.Bucket(boltCreatedBucket)
+	if created.Get([]byte(p.Key)) == nil {
+		if err := created.Put([]byte(p.Key), encoded); err != nil {
+			return err
+		}
+	}
+	if err := tx.Bucket(boltTombstoneBucket).Delete([]byte(p.Key)); err != nil {
+		return err
+	}
+	p.Index = index
+	return nil
+}
+
+func boltDeleteTree(tx *bolt.Tx, prefix string) error {
+	index, err := tx.Bucket(boltBucket).NextSequence()
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	if err := boltPruneTombstones(tx, now); err != nil {
+		return err
+	}
+	encoded := boltTombstone(index, now)
+
+	p := []byte(prefix)
+	tombstones := tx.Bucket(boltTombstoneBucket)
+	c := tx.Bucket(boltBucket).Cursor()
+	for k, _ := c.Seek(p); k != nil && bytes.HasPrefix(k, p); k, _ = c.Seek(p) {
+		if err := tombstones.Put(copyBytes(k), encoded); err != nil {
+			return err
+		}
+		if err := c.Delete(); err != nil {
+			return err
+		}
+	}
+
+	for _, name := range [][]byte{boltIndexBucket, boltCreatedBucket} {
+		c = tx.Bucket(name).Cursor()
+		for k, _ := c.Seek(p); k != nil && bytes.HasPrefix(k, p); k, _ = c.Seek(p) {
+			if err := c.Delete(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func boltDelete(tx *bolt.Tx, key string) error {
+	bucket := tx.Bucket(boltBucket)
+	if bucket.Get([]byte(key)) == nil {
+		return nil
+	}
+	index, err := bucket.NextSequence()
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	if err := boltPruneTombstones(tx, now); err != nil {
+		return err
+	}
+	if err := tx.Bucket(boltTombstoneBucket).Put([]byte(key), boltTombstone(index, now)); err != nil {
+		return err
+	}
+	if err := bucket.Delete([]byte(key)); err != nil {
+		return err
+	}
+	if err := tx.Bucket(boltCreatedBucket).Delete([]byte(key)); err != nil {
+		return err
+	}
+	return tx.Bucket(boltIndexBucket).Delete([]byte(key))
+}
+
+func boltPair(tx *bolt.Tx, key []byte, value []byte) *kvPair {
+	pair := &kvPair{Key: string(key), Value: copyBytes(value), Index: boltIndex(tx, key)}
+	pair.CreateIndex = pair.Index
+	// Keys written before the created bucket existed fall back to their
+	// modify index.
+	if encoded := tx.Bucket(boltCreatedBucket).Get(key); len(encoded) == 8 {
+		pair.CreateIndex = binary.BigEndian.Uint64(encoded)
+	}
+	return pair
+}
+
+// boltIndex returns the modify index of key, 0 if it does not exist.
+func boltIndex(tx *bolt.Tx, key []byte) uint64 {
+	encoded := tx.Bucket(boltIndexBucket).Get(key)
+	if len(encoded) != 8 {
+		return 0
+	}
+	return binary.BigEndian.Uint64(encoded)
+}
+
+// boltTombstone encodes the index and time a key was deleted at.
+func boltTombstone(index uint64, deleted time.Time) []byte {
+	encoded := make([]byte, 16)
+	binary.BigEndian.PutUint64(encoded, index)
+	binary.BigEndian.PutUint64(encoded[8:], uint64(deleted.UnixNano()))
+	return encoded
+}
+
+// boltPruneTombstones drops the tombstones older than tombstoneTTL.
+// Tombstones written before the time was recorded go with the first prune.
+func boltPruneTombstones(tx *bolt.Tx, now time.Time) error {
+	c := tx.Bucket(boltTombstoneBucket).Cursor()
+	for k, v := c.First(); k != nil; {
+		if len(v) < 16 || now.Sub(time.Unix(0, int64(binary.BigEndian.Uint64(v[8:])))) > tombstoneTTL {
+			key := copyBytes(k)
+			if err := c.Delete(); err != nil {
+				return err
+			}
+			k, v = c.Seek(key)
+			continue
+		}
+		k, v = c.Next()
+	}
+	return nil
+}
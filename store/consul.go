@@ -0,0 +1,177 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"github.com/hashicorp/consul/api"
+	"os"
+	"time"
+)
+
+// consulTxnBatch is the most ops Consul accepts in one transaction.
+const consulTxnBatch = 64
+
+type consulKV struct {
+	cli *api.Client
+}
+
+// NewConsul returns a Store backed by the Consul agent at DB:DBPORT.
+func NewConsul() (Store, error) {
+	db := os.Getenv("DB")
+	dbport := os.Getenv("DBPORT")
+
+	config := api.DefaultConfig()
+	config.Address = fmt.Sprintf("%s:%s", db, dbport)
+	client, err := api.NewClient(config)
+	if err != nil {
+		return nil, err
+	}
+
+	ps, err := openKVStore(&consulKV{cli: client})
+	if err != nil {
+		return nil, err
+	}
+	return ps, nil
+}
+
+func (c *consulKV) Get(ctx context.Context, key string) (*kvPair, error) {
+	pair, _, err := c.cli.KV().Get(key, (&api.QueryOptions{}).WithContext(ctx))
+	if err != nil || pair == nil {
+		return nil, err
+	}
+	return consulPair(pair), nil
+}
+
+func (c *consulKV) List(ctx context.Context, prefix string) ([]*kvPair, error) {
+	data, _, err := c.cli.KV().List(prefix, (&api.QueryOptions{}).WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+
+	pairs := make([]*kvPair, 0, len(data))
+	for _, pair := range data {
+		pairs = append(pairs, consulPair(pair))
+	}
+	return pairs, nil
+}
+
+func (c *consulKV) Keys(ctx context.Context, prefix string) ([]string, error) {
+	keys, _, err := c.cli.KV().Keys(prefix, "", (&api.QueryOptions{}).WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// GetMany reads the keys in read-only transactions of consulTxnBatch ops.
+func (c *consulKV) GetMany(ctx context.Context, keys []string) ([]*kvPair, error) {
+	pairs := make([]*kvPair, 0, len(keys))
+	for start := 0; start < len(keys); start += consulTxnBatch {
+		end := start + consulTxnBatch
+		if end > len(keys) {
+			end = len(keys)
+		}
+		txn := api.KVTxnOps{}
+		for _, key := range keys[start:end] {
+			txn = append(txn, &api.KVTxnOp{Verb: api.KVGetOrEmpty, Key: key})
+		}
+		ok, resp, _, err := c.cli.KV().Txn(txn, (&api.QueryOptions{}).WithContext(ctx))
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			return nil, fmt.Errorf("consul read transaction failed: %v", resp.Errors)
+		}
+		for _, result := range resp.Results {
+			// get-or-empty answers missing keys without a modify index.
+			if result != nil && result.ModifyIndex != 0 {
+				pairs = append(pairs, consulPair(result))
+			}
+		}
+	}
+	return pairs, nil
+}
+
+// GetIndexes falls back to GetMany, Consul only hands out the indexes of a
+// key together with its value.
+func (c *consulKV) GetIndexes(ctx context.Context, keys []string) ([]*kvPair, error) {
+	pairs, err := c.GetMany(ctx, keys)
+	if err != nil {
+		return nil, err
+	}
+	for _, pair := range pairs {
+		pair.Value = nil
+	}
+	return pairs, nil
+}
+
+func (c *consulKV) ListWait(ctx context.Context, prefix string, index uint64, wait time.Duration) ([]*kvPair, uint64, error) {
+	q := &api.QueryOptions{WaitIndex: index, WaitTime: wait}
+	data, meta, err := c.cli.KV().List(prefix, q.WithContext(ctx))
+	if err != nil {
+		return nil, 0, err
+	}
+
+	pairs := make([]*kvPair, 0, len(data))
+	for _, pair := range data {
+		pairs = append(pairs, consulPair(pair))
+	}
+	return pairs, meta.LastIndex, nil
+}
+
+func (c *consulKV) Put(ctx context.Context, p *kvPair) error {
+	_, err := c.cli.KV().Put(&api.KVPair{Key: p.Key, Value: p.Value}, (&api.WriteOptions{}).WithContext(ctx))
+	return err
+}
+
+func (c *consulKV) DeleteTree(ctx context.Context, prefix string) error {
+	_, err := c.cli.KV().DeleteTree(prefix, (&api.WriteOptions{}).WithContext(ctx))
+	return err
+}
+
+func (c *consulKV) Txn(ctx context.Context, ops []*kvOp) (bool, error) {
+	txn := api.KVTxnOps{}
+	for _, op := range ops {
+		switch op.Verb {
+		case kvSet:
+			txn = append(txn, &api.KVTxnOp{Verb: api.KVSet, Key: op.Pair.Key, Value: op.Pair.Value})
+		case kvDeleteTree:
+			txn = append(txn, &api.KVTxnOp{Verb: api.KVDeleteTree, Key: op.Pair.Key})
+		case kvDelete:
+			txn = append(txn, &api.KVTxnOp{Verb: api.KVDelete, Key: op.Pair.Key})
+		case kvCheckIndex:
+			if op.Pair.Index == 0 {
+				txn = append(txn, &api.KVTxnOp{Verb: api.KVCheckNotExists, Key: op.Pair.Key})
+			} else {
+				txn = append(txn, &api.KVTxnOp{Verb: api.KVCheckIndex, Key: op.Pair.Key, Index: op.Pair.Index})
+			}
+		}
+	}
+
+	ok, resp, _, err := c.cli.KV().Txn(txn, (&api.QueryOptions{}).WithContext(ctx))
+	if err != nil {
+		return false, err
+	}
+	if !ok {
+		// Consul rolls the whole transaction back on any failed op, the
+		// check ops are the only ones expected to fail.
+		return false, nil
+	}
+
+	written := map[string]uint64{}
+	for _, result := range resp.Results {
+		if result != nil {
+			written[result.Key] = result.ModifyIndex
+		}
+	}
+	for _, op := range ops {
+		if op.Verb == kvSet {
+			op.Pair.Index = written[op.Pair.Key]
+		}
+	}
+	return true, nil
+}
+
+func consulPair(pair *api.KVPair) *kvPair {
+	return &kvPair{Key: pair.Key, Value: pair.Value, Index: pair.ModifyIndex, CreateIndex: pair.CreateIndex}
+}
@@ -0,0 +1,227 @@
+package store
+
+import (
+	"context"
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"os"
+	"strings"
+	"time"
+)
+
+// etcdTxnBatch stays below the default limit of 128 ops per transaction.
+const etcdTxnBatch = 100
+
+// etcdKV maps the Consul key layout 1:1 onto etcd v3 keys.
+type etcdKV struct {
+	cli *clientv3.Client
+}
+
+// NewEtcd returns a Store backed by the etcd cluster at endpoints. When no
+// endpoints are given the comma separated ETCD_ENDPOINTS env var is used,
+// falling back to localhost:2379.
+func NewEtcd(endpoints ...string) (Store, error) {
+	if len(endpoints) == 0 {
+		if env := os.Getenv("ETCD_ENDPOINTS"); env != "" {
+			endpoints = strings.Split(env, ",")
+		} else {
+			endpoints = []string{"localhost:2379"}
+		}
+	}
+
+	client, err := clientv3.New(clientv3.Config{
+		Endpoints:   endpoints,
+		DialTimeout: 5 * time.Second,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	ps, err := openKVStore(&etcdKV{cli: client})
+	if err != nil {
+		client.Close()
+		return nil, err
+	}
+	return ps, nil
+}
+
+func (e *etcdKV) Get(ctx context.Context, key string) (*kvPair, error) {
+	resp, err := e.cli.Get(ctx, key)
+	if err != nil || len(resp.Kvs) == 0 {
+		return nil, err
+	}
+	return etcdPair(resp.Kvs[0]), nil
+}
+
+func (e *etcdKV) List(ctx context.Context, prefix string) ([]*kvPair, error) {
+	pairs, _, err := e.list(ctx, prefix)
+	return pairs, err
+}
+
+func (e *etcdKV) Keys(ctx context.Context, prefix string) ([]string, error) {
+	resp, err := e.cli.Get(ctx, prefix, etcdPrefix(prefix), clientv3.WithKeysOnly(),
+		clientv3.WithSort(clientv3.SortByKey, clientv3.SortAscend))
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make([]string, 0, len(resp.Kvs))
+	for _, pair := range resp.Kvs {
+		keys = append(keys, string(pair.Key))
+	}
+	return keys, nil
+}
+
+// GetMany reads the keys in transactions of etcdTxnBatch gets, each one a
+// consistent snapshot.
+func (e *etcdKV) GetMany(ctx context.Context, keys []string) ([]*kvPair, error) {
+	pairs := make([]*kvPair, 0, len(keys))
+	for start := 0; start < len(keys); start += etcdTxnBatch {
+		end := start + etcdTxnBatch
+		if end > len(keys) {
+			end = len(keys)
+		}
+		ops := []clientv3.Op{}
+		for _, key := range keys[start:end] {
+			ops = append(ops, clientv3.OpGet(key))
+		}
+		resp, err := e.cli.Txn(ctx).Then(ops...).Commit()
+		if err != nil {
+			return nil, err
+		}
+		for _, r := range resp.Responses {
+			for _, pair := range r.GetResponseRange().Kvs {
+				pairs = append(pairs, etcdPair(pair))
+			}
+		}
+	}
+	return pairs, nil
+}
+
+// GetIndexes reads the keys like GetMany, but only their revisions.
+func (e *etcdKV) GetIndexes(ctx context.Context, keys []string) ([]*kvPair, error) {
+	pairs := make([]*kvPair, 0, len(keys))
+	for start := 0; start < len(keys); start += etcdTxnBatch {
+		end := start + etcdTxnBatch
+		if end > len(keys) {
+			end = len(keys)
+		}
+		ops := []clientv3.Op{}
+		for _, key := range keys[start:end] {
+			ops = append(ops, clientv3.OpGet(key, clientv3.WithKeysOnly()))
+		}
+		resp, err := e.cli.Txn(ctx).Then(ops...).Commit()
+		if err != nil {
+			return nil, err
+		}
+		for _, r := range resp.Responses {
+			for _, pair := range r.GetResponseRange().Kvs {
+				pairs = append(pairs, etcdPair(pair))
+			}
+		}
+	}
+	return pairs, nil
+}
+
+// ListWait uses the cluster revision as index. Watching from the next
+// revision replays anything that changed under prefix in the meantime.
+func (e *etcdKV) ListWait(ctx context.Context, prefix string, index uint64, wait time.Duration) ([]*kvPair, uint64, error) {
+	wctx, cancel := context.WithTimeout(ctx, wait)
+	select {
+	case <-e.cli.Watch(wctx, prefix, etcdPrefix(prefix), clientv3.WithRev(int64(index)+1)):
+	case <-wctx.Done():
+	}
+	cancel()
+
+	if ctx.Err() != nil {
+		return nil, 0, ctx.Err()
+	}
+	return e.list(ctx, prefix)
+}
+
+func (e *etcdKV) list(ctx context.Context, prefix string) ([]*kvPair, uint64, error) {
+	resp, err := e.cli.Get(ctx, prefix, etcdPrefix(prefix),
+		clientv3.WithSort(clientv3.SortByKey, clientv3.SortAscend))
+	if err != nil {
+		return nil, 0, err
+	}
+
+	pairs := make([]*kvPair, 0, len(resp.Kvs))
+	for _, pair := range resp.Kvs {
+		pairs = append(pairs, etcdPair(pair))
+	}
+	return pairs, uint64(resp.Header.Revision), nil
+}
+
+func (e *etcdKV) Put(ctx context.Context, p *kvPair) error {
+	_, err := e.cli.Put(ctx, p.Key, string(p.Value))
+	return err
+}
+
+func (e *etcdKV) DeleteTree(ctx context.Context, prefix string) error {
+	_, err := e.cli.Delete(ctx, prefix, etcdPrefix(prefix))
+	return err
+}
+
+func (e *etcdKV) Txn(ctx context.Context, ops []*kvOp) (bool, error) {
+	cmps := []clientv3.Cmp{}
+	then := []clientv3.Op{}
+	for _, op := range ops {
+		switch op.Verb {
+		case kvSet:
+			then = append(then, clientv3.OpPut(op.Pair.Key, string(op.Pair.Value)))
+		case kvDeleteTree:
+			then = append(then, clientv3.OpDelete(op.Pair.Key, etcdPrefix(op.Pair.Key)))
+		case kvDelete:
+			then = append(then, clientv3.OpDelete(op.Pair.Key))
+		case kvCheckIndex:
+			cmps = append(cmps, clientv3.Compare(clientv3.ModRevision(op.Pair.Key), "=", int64(op.Pair.Index)))
+		}
+	}
+
+	resp, err := e.cli.Txn(ctx).If(cmps...).Then(then...).Commit()
+	if err != nil || !resp.Succeeded {
+		return false, err
+	}
+	for _, op := range ops {
+		if op.Verb == kvSet {
+			op.Pair.Index = uint64(resp.Header.Revision)
+		}
+	}
+	return true, nil
+}
+
+func (e *etcdKV) Watch(ctx context.Context, prefix string) <-chan struct{} {
+	changes := make(chan struct{})
+	go func() {
+		defer close(changes)
+		for resp := range e.cli.Watch(ctx, prefix, etcdPrefix(prefix)) {
+			if resp.Err() != nil || len(resp.Events) == 0 {
+				continue
+			}
+			select {
+			case changes <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return changes
+}
+
+func (e *etcdKV) Close() error {
+	return e.cli.Close()
+}
+
+// etcdPrefix makes a range request match every key starting with prefix.
+// An empty prefix has to be spelled out as the whole keyspace.
+func etcdPrefix(prefix string) clientv3.OpOption {
+	if prefix == "" {
+		return clientv3.WithFromKey()
+	}
+	return clientv3.WithPrefix()
+}
+
+func etcdPair(pair *mvccpb.KeyValue) *kvPair {
+	return &kvPair{Key: string(pair.Key), Value: pair.Value, Index: uint64(pair.ModRevision), CreateIndex: uint64(pair.CreateRevision)}
+}
@@ -0,0 +1,265 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+var (
+	// ErrReferenced is returned when deleting a config that groups still
+	// hold, see ReferencedError.
+	ErrReferenced = errors.New("config is referenced by groups")
+
+	// ErrCascadeTooLarge is returned when a cascading delete detaches the
+	// config from more groups than fit in one transaction. Remove the
+	// config from some groups first.
+	ErrCascadeTooLarge = errors.New("delete touches too many keys for one transaction")
+)
+
+// ReferencedError lists the groups, as "id/version", that hold a config
+// which was about to be deleted.
+type ReferencedError struct {
+	Groups []string
+}
+
+func (e *ReferencedError) Error() string {
+	return fmt.Sprintf("%v: %s", ErrReferenced, strings.Join(e.Groups, ", "))
+}
+
+func (e *ReferencedError) Is(target error) bool {
+	return target == ErrReferenced
+}
+
+// groupIndexTxn collects the reverse index changes of one transaction, so
+// every index/groups/<config id> key is touched at most once.
+//
+// Each key lists the keys of the groups holding the config id, by copy or
+// by reference. Group writes rewrite the keys of all their config ids,
+// even when the list stays the same, and guard them by modify index. A
+// config delete that read a key therefore fails on any concurrent change to
+// a group holding that config.
+type groupIndexTxn struct {
+	kv      kv
+	pairs   map[string]*kvPair
+	keys    map[string]map[string]bool
+	changed map[string]bool
+}
+
+func newGroupIndexTxn(kv kv) *groupIndexTxn {
+	return &groupIndexTxn{
+		kv:      kv,
+		pairs:   map[string]*kvPair{},
+		keys:    map[string]map[string]bool{},
+		changed: map[string]bool{},
+	}
+}
+
+// load reads the group keys listed for config id.
+func (t *groupIndexTxn) load(ctx context.Context, id string) (map[string]bool, error) {
+	key := constructGroupIndexKey(id)
+	if keys, ok := t.keys[key]; ok {
+		return keys, nil
+	}
+	pair, err := t.kv.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	keys := map[string]bool{}
+	if pair != nil {
+		list := []string{}
+		if err := json.Unmarshal(pair.Value, &list); err != nil {
+			return nil, err
+		}
+		for _, k := range list {
+			keys[k] = true
+		}
+	}
+	t.pairs[key] = pair
+	t.keys[key] = keys
+	return keys, nil
+}
+
+// update records that the group under key changed from old to group,
+// either may be nil for a created or deleted group.
+func (t *groupIndexTxn) update(ctx context.Context, key string, old *Group, group *Group) error {
+	ids := map[string]bool{}
+	for _, id := range memberIds(old) {
+		ids[id] = false
+	}
+	for _, id := range memberIds(group) {
+		ids[id] = true
+	}
+	for id, member := range ids {
+		keys, err := t.load(ctx, id)
+		if err != nil {
+			return err
+		}
+		if member {
+			keys[key] = true
+		} else {
+			delete(keys, key)
+		}
+		t.changed[constructGroupIndexKey(id)] = true
+	}
+	return nil
+}
+
+// ops checks every loaded key against the modify index it was read at and
+// writes the changed ones.
+func (t *groupIndexTxn) ops() ([]*kvOp, error) {
+	ops := []*kvOp{}
+	for key, keys := range t.keys {
+		var index uint64
+		if pair := t.pairs[key]; pair != nil {
+			index = pair.Index
+		}
+		ops = append(ops, &kvOp{Verb: kvCheckIndex, Pair: &kvPair{Key: key, Index: index}})
+		if !t.changed[key] {
+			continue
+		}
+
+		list := sortedKeys(keys)
+		if len(list) == 0 {
+			if t.pairs[key] != nil {
+				ops = append(ops, &kvOp{Verb: kvDelete, Pair: &kvPair{Key: key}})
+			}
+			continue
+		}
+		data, err := json.Marshal(list)
+		if err != nil {
+			return nil, err
+		}
+		ops = append(ops, &kvOp{Verb: kvSet, Pair: &kvPair{Key: key, Value: data}})
+	}
+	return ops, nil
+}
+
+func sortedKeys(set map[string]bool) []string {
+	keys := make([]string, 0, len(set))
+	for key := range set {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// memberIds lists the ids of the configs group holds, by copy or by
+// reference.
+func memberIds(group *Group) []string {
+	if group == nil {
+		return nil
+	}
+	ids := []string{}
+	for _, config := range group.Configs {
+		ids = append(ids, config.Id)
+	}
+	for _, ref := range group.Refs {
+		ids = append(ids, ref.Id)
+	}
+	return ids
+}
+
+// memberChecks guards the members group gains over old, which is nil for
+// a new group, in the transaction writing it. Copies read from the store,
+// those with an Index, have to still be at that index, refs have to still
+// resolve to the config they resolve to now. A config delete racing with
+// the group write thus makes one of the two fail, instead of leaving the
+// group with a deleted member.
+func (ps *kvStore) memberChecks(ctx context.Context, old *Group, group *Group) ([]*kvOp, error) {
+	ops := []*kvOp{}
+	for _, config := range group.Configs {
+		if config.Index == 0 {
+			continue
+		}
+		key := constructKey(config.Id, config.Version, config.Labels.String())
+		ops = append(ops, &kvOp{Verb: kvCheckIndex, Pair: &kvPair{Key: key, Index: config.Index}})
+	}
+
+	known := map[string]bool{}
+	if old != nil {
+		for _, ref := range old.Refs {
+			known[refKey(ref)] = true
+		}
+	}
+	for _, ref := range group.Refs {
+		if known[refKey(ref)] {
+			continue
+		}
+		known[refKey(ref)] = true
+		version, err := ps.ResolveConfigVersion(ctx, ref.Id, ref.Version)
+		if errors.Is(err, ErrVersionNotFound) {
+			return nil, fmt.Errorf("%w: %s version %s", ErrConfigNotFound, ref.Id, ref.Version)
+		}
+		if err != nil {
+			return nil, err
+		}
+		key := constructKey(ref.Id, version, ref.Labels.String())
+		pair, err := ps.kv.Get(ctx, key)
+		if err != nil {
+			return nil, err
+		}
+		if pair == nil {
+			return nil, fmt.Errorf("%w: %s version %s", ErrConfigNotFound, ref.Id, ref.Version)
+		}
+		ops = append(ops, &kvOp{Verb: kvCheckIndex, Pair: &kvPair{Key: key, Index: pair.Index}})
+	}
+	return ops, nil
+}
+
+func refKey(ref ConfigRef) string {
+	return ref.Id + "/" + ref.Version + "/" + ref.Labels.String()
+}
+
+// detachConfigs removes the members of group that are the deleted configs.
+// A ref with a version range only goes once none of the remaining versions
+// of the config satisfy it, until then it resolves to another version.
+func detachConfigs(group *Group, deleted []*Config, remaining []string) bool {
+	matches := func(id string, version string, labels Labels) bool {
+		for _, config := range deleted {
+			if id != config.Id || labels.String() != config.Labels.String() {
+				continue
+			}
+			if !IsVersionRange(version) {
+				if hasVersion([]string{config.Version}, version) {
+					return true
+				}
+				continue
+			}
+			if _, err := resolveVersion([]string{config.Version}, version); err != nil {
+				continue
+			}
+			if _, err := resolveVersion(remaining, version); err != nil {
+				return true
+			}
+		}
+		return false
+	}
+
+	changed := false
+	configs := []Config{}
+	for _, config := range group.Configs {
+		if matches(config.Id, config.Version, config.Labels) {
+			changed = true
+			continue
+		}
+		configs = append(configs, config)
+	}
+	refs := []ConfigRef{}
+	for _, ref := range group.Refs {
+		if matches(ref.Id, ref.Version, ref.Labels) {
+			changed = true
+			continue
+		}
+		refs = append(refs, ref)
+	}
+	if changed && group.IsReference() {
+		group.Refs = refs
+	} else if changed {
+		group.Configs = configs
+	}
+	return changed
+}
@@ -3,6 +3,8 @@ package store
 import (
 	"fmt"
 	"github.com/google/uuid"
+	"net/url"
+	"strings"
 )
 
 const (
@@ -19,12 +21,26 @@ const (
 	//groupsLabels  = "groups/%s/%s/%s"
 	all       = "configs"
 	allGroups = "groups"
+
+	// labelIndex maps every label of a config to its key:
+	// index/labels/<label>/<value>/<id>/<version> -> configs/...
+	labelIndex       = "index/labels/%s/%s/%s/%s"
+	labelIndexPrefix = "index/labels/%s/%s/"
+
+	// groupIndex lists the keys of the groups holding a config id:
+	// index/groups/<id> -> ["groups/<id>/<version>/", ...]
+	groupIndex = "index/groups/%s"
+
+	// idempotency holds the responses to requests with an idempotency key:
+	// idempotency/<scope>/<key>, both path escaped to stay one segment.
+	idempotency       = "idempotency/%s/%s"
+	idempotencyPrefix = "idempotency/"
 )
 
-func generateKey(version string, labels string) (string, string) {
+func generateKey(version string, labels Labels) (string, string) {
 	id := uuid.New().String()
-	if labels != "" {
-		return fmt.Sprintf(configsLabels, id, version, labels), id
+	if len(labels) > 0 {
+		return fmt.Sprintf(configsLabels, id, version, labels.String()), id
 	} else {
 		return fmt.Sprintf(configs, id, version), id
 	}
@@ -42,6 +58,7 @@ func generateGroupKey(version string) (string, string) {
 }
 
 func constructKey(id string, version string, labels string) string {
+	version = normalizeVersion(version)
 	if labels != "" {
 		return fmt.Sprintf(configsLabels, id, version, labels)
 	} else {
@@ -49,10 +66,39 @@ func constructKey(id string, version string, labels string) string {
 	}
 
 }
+func constructLabelIndexKeys(config *Config) []string {
+	keys := make([]string, 0, len(config.Labels))
+	for label, value := range config.Labels {
+		keys = append(keys, fmt.Sprintf(labelIndex, label, value, config.Id, config.Version))
+	}
+	return keys
+}
+
+func constructGroupIndexKey(id string) string {
+	return fmt.Sprintf(groupIndex, id)
+}
+
+func constructIdempotencyKey(scope string, key string) string {
+	return fmt.Sprintf(idempotency, url.PathEscape(scope), url.PathEscape(key))
+}
+
+// versionPairs drops the pairs listed under the configs/<id>/<version>
+// prefix that belong to a longer version, e.g. 1.0.10 when listing 1.0.1.
+func versionPairs(data []*kvPair, id string, version string) []*kvPair {
+	key := constructKey(id, version, "")
+	pairs := []*kvPair{}
+	for _, pair := range data {
+		if pair.Key == key || strings.HasPrefix(pair.Key, key+"/") {
+			pairs = append(pairs, pair)
+		}
+	}
+	return pairs
+}
 func constructKey2(id string) string {
 	return fmt.Sprintf(configs2, id)
 }
 func constructGroupKey(id string, version string) string {
+	version = normalizeVersion(version)
 	/*if labels != "" {
 		return fmt.Sprintf(groupsLabels, id, version, labels)
 	} else {
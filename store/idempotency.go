@@ -0,0 +1,153 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	tracer "example.com/mod/tracer"
+	"time"
+)
+
+// IdempotentResponse is the response to a request with an idempotency key,
+// replayed when the request is retried with the same key.
+type IdempotentResponse struct {
+	// Status is 0 while the first request is still being handled.
+	Status      int       `json:"status"`
+	ContentType string    `json:"contentType,omitempty"`
+	Location    string    `json:"location,omitempty"`
+	Body        []byte    `json:"body,omitempty"`
+	Fingerprint string    `json:"fingerprint"`
+	ExpiresAt   time.Time `json:"expiresAt"`
+}
+
+func (ps *kvStore) ReserveIdempotencyKey(ctx context.Context, scope string, key string, fingerprint string, expires time.Time) (*IdempotentResponse, uint64, error) {
+	span := tracer.StartSpanFromContext(ctx, "ReserveIdempotencyKey")
+	defer span.Finish()
+
+	k := constructIdempotencyKey(scope, key)
+	pair, err := ps.kv.Get(ctx, k)
+	if err != nil {
+		tracer.LogError(span, err)
+		return nil, 0, err
+	}
+	var index uint64
+	if pair != nil {
+		stored := &IdempotentResponse{}
+		if err := json.Unmarshal(pair.Value, stored); err != nil {
+			tracer.LogError(span, err)
+			return nil, 0, err
+		}
+		if time.Now().Before(stored.ExpiresAt) {
+			return stored, 0, nil
+		}
+		// Expired but not swept yet, or a lease whose request never
+		// finished: take it over.
+		index = pair.Index
+	}
+
+	claim, err := ps.writeIdempotencyKey(ctx, k, index, &IdempotentResponse{Fingerprint: fingerprint, ExpiresAt: expires})
+	if err != nil {
+		tracer.LogError(span, err)
+		return nil, 0, err
+	}
+	return nil, claim, nil
+}
+
+func (ps *kvStore) RenewIdempotencyKey(ctx context.Context, scope string, key string, claim uint64, fingerprint string, expires time.Time) (uint64, error) {
+	span := tracer.StartSpanFromContext(ctx, "RenewIdempotencyKey")
+	defer span.Finish()
+
+	claim, err := ps.writeIdempotencyKey(ctx, constructIdempotencyKey(scope, key), claim, &IdempotentResponse{Fingerprint: fingerprint, ExpiresAt: expires})
+	if err != nil {
+		tracer.LogError(span, err)
+		return 0, err
+	}
+	return claim, nil
+}
+
+func (ps *kvStore) CompleteIdempotencyKey(ctx context.Context, scope string, key string, claim uint64, response *IdempotentResponse) error {
+	span := tracer.StartSpanFromContext(ctx, "CompleteIdempotencyKey")
+	defer span.Finish()
+
+	if _, err := ps.writeIdempotencyKey(ctx, constructIdempotencyKey(scope, key), claim, response); err != nil {
+		tracer.LogError(span, err)
+		return err
+	}
+	return nil
+}
+
+func (ps *kvStore) ReleaseIdempotencyKey(ctx context.Context, scope string, key string, claim uint64) error {
+	span := tracer.StartSpanFromContext(ctx, "ReleaseIdempotencyKey")
+	defer span.Finish()
+
+	k := constructIdempotencyKey(scope, key)
+	ok, err := ps.kv.Txn(ctx, []*kvOp{
+		{Verb: kvCheckIndex, Pair: &kvPair{Key: k, Index: claim}},
+		{Verb: kvDelete, Pair: &kvPair{Key: k}},
+	})
+	if err != nil {
+		tracer.LogError(span, err)
+		return err
+	}
+	if !ok {
+		tracer.LogError(span, ErrConflict)
+		return ErrConflict
+	}
+	return nil
+}
+
+// writeIdempotencyKey writes response under k if k is still at index, 0
+// for a key that must not exist, and returns the index it was written at.
+func (ps *kvStore) writeIdempotencyKey(ctx context.Context, k string, index uint64, response *IdempotentResponse) (uint64, error) {
+	data, err := json.Marshal(response)
+	if err != nil {
+		return 0, err
+	}
+	p := &kvPair{Key: k, Value: data}
+	ok, err := ps.kv.Txn(ctx, []*kvOp{
+		{Verb: kvCheckIndex, Pair: &kvPair{Key: k, Index: index}},
+		{Verb: kvSet, Pair: p},
+	})
+	if err != nil {
+		return 0, err
+	}
+	if !ok {
+		return 0, ErrConflict
+	}
+	return p.Index, nil
+}
+
+func (ps *kvStore) ExpireIdempotencyKeys(ctx context.Context, now time.Time) (int, error) {
+	span := tracer.StartSpanFromContext(ctx, "ExpireIdempotencyKeys")
+	defer span.Finish()
+
+	data, err := ps.kv.List(ctx, idempotencyPrefix)
+	if err != nil {
+		tracer.LogError(span, err)
+		return 0, err
+	}
+
+	expired := 0
+	for _, pair := range data {
+		stored := &IdempotentResponse{}
+		if err := json.Unmarshal(pair.Value, stored); err != nil {
+			tracer.LogError(span, err)
+			return expired, err
+		}
+		if now.Before(stored.ExpiresAt) {
+			continue
+		}
+		// Skip keys that were taken over since they were listed.
+		ok, err := ps.kv.Txn(ctx, []*kvOp{
+			{Verb: kvCheckIndex, Pair: &kvPair{Key: pair.Key, Index: pair.Index}},
+			{Verb: kvDelete, Pair: &kvPair{Key: pair.Key}},
+		})
+		if err != nil {
+			tracer.LogError(span, err)
+			return expired, err
+		}
+		if ok {
+			expired++
+		}
+	}
+	return expired, nil
+}
@@ -0,0 +1,93 @@
+package store
+
+import (
+	"context"
+	"time"
+)
+
+// kvPair is a single entry of the key/value backend. Index is the
+// backend's modify index of the key (Consul ModifyIndex, etcd ModRevision),
+// it grows every time the key is written. CreateIndex is the index the key
+// was first written at and stays the same until it is deleted.
+type kvPair struct {
+	Key         string
+	Value       []byte
+	Index       uint64
+	CreateIndex uint64
+}
+
+// kv is the minimal key/value backend the store is built on. Keys follow
+// the Consul layout from helper.go and List/DeleteTree work on prefixes.
+type kv interface {
+	// Get returns the pair stored under key, or nil if it does not exist.
+	Get(ctx context.Context, key string) (*kvPair, error)
+
+	// List returns all pairs whose key starts with prefix, ordered by key.
+	List(ctx context.Context, prefix string) ([]*kvPair, error)
+
+	// Keys returns the keys starting with prefix, ordered, without reading
+	// their values.
+	Keys(ctx context.Context, prefix string) ([]string, error)
+
+	// GetMany returns the pairs stored under keys, in the same order. Keys
+	// that do not exist are skipped. Backends read them in batches rather
+	// than one request per key.
+	GetMany(ctx context.Context, keys []string) ([]*kvPair, error)
+
+	// GetIndexes is GetMany without the values, for backends that can read
+	// the indexes of a key on their own.
+	GetIndexes(ctx context.Context, keys []string) ([]*kvPair, error)
+
+	// ListWait is List as a blocking query. It waits until something under
+	// prefix changed after index, or wait elapsed, and returns the pairs
+	// together with the index to pass in to wait for the next change.
+	ListWait(ctx context.Context, prefix string, index uint64, wait time.Duration) ([]*kvPair, uint64, error)
+
+	// Put writes the pair, overwriting any existing value.
+	Put(ctx context.Context, p *kvPair) error
+
+	// DeleteTree removes every key that starts with prefix.
+	DeleteTree(ctx context.Context, prefix string) error
+
+	// Txn applies all ops atomically, or none of them. It returns false
+	// when a kvCheckIndex op failed. On success the Index of every kvSet
+	// pair is updated to the index it was written at.
+	Txn(ctx context.Context, ops []*kvOp) (bool, error)
+}
+
+// maxTxnOps is the most ops of a transaction that every backend accepts.
+// Consul rejects more than 64, etcd more than 128 by default.
+const maxTxnOps = 64
+
+// tombstoneTTL is how long the local backends remember deleted keys for
+// blocking lists. It outlasts the longest wait a client can ask for, after
+// that a watcher of a deleted prefix starts over like it does with Consul
+// once its tombstones are reaped.
+const tombstoneTTL = time.Hour
+
+type kvVerb int
+
+const (
+	// kvSet writes Pair.
+	kvSet kvVerb = iota
+	// kvDeleteTree removes every key starting with Pair.Key.
+	kvDeleteTree
+	// kvCheckIndex aborts the transaction unless Pair.Key is currently at
+	// Pair.Index. Index 0 means the key must not exist.
+	kvCheckIndex
+	// kvDelete removes exactly Pair.Key, if it exists.
+	kvDelete
+)
+
+// kvOp is one operation of a kv transaction.
+type kvOp struct {
+	Verb kvVerb
+	Pair *kvPair
+}
+
+// kvWatcher is implemented by backends that can push change notifications.
+type kvWatcher interface {
+	// Watch sends on the returned channel every time a key under prefix
+	// changes. The channel is closed once ctx is done.
+	Watch(ctx context.Context, prefix string) <-chan struct{}
+}
@@ -0,0 +1,277 @@
+package store
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+var (
+	// ErrInvalidLabels is returned for labels that cannot be parsed or
+	// stored.
+	ErrInvalidLabels = errors.New("invalid labels")
+
+	// ErrInvalidSelector is returned for a label selector that cannot be
+	// parsed.
+	ErrInvalidSelector = errors.New("invalid selector")
+)
+
+// Labels of a config or group. Config labels are stored in canonical form,
+// see String, as the last segment of the config key. Group labels only live
+// in the stored group, its key stays groups/<id>/<version>/.
+//
+// swagger:model Labels
+type Labels map[string]string
+
+// ParseLabels parses the canonical "k=v,k2=v2" form, in any order. A key
+// without a value, like the old free form labels "l1", gets an empty value.
+func ParseLabels(s string) (Labels, error) {
+	labels := Labels{}
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		key, value, _ := strings.Cut(item, "=")
+		key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+		if _, ok := labels[key]; ok {
+			return nil, fmt.Errorf("%w: duplicate label %q", ErrInvalidLabels, key)
+		}
+		labels[key] = value
+	}
+	if err := labels.Validate(); err != nil {
+		return nil, err
+	}
+	return labels, nil
+}
+
+// Validate checks that keys and values can be embedded in a store key and
+// parsed back.
+func (l Labels) Validate() error {
+	for key, value := range l {
+		if key == "" || strings.ContainsAny(key, labelReserved+"!") {
+			return fmt.Errorf("%w: key %q", ErrInvalidLabels, key)
+		}
+		if strings.ContainsAny(value, labelReserved) {
+			return fmt.Errorf("%w: value %q of label %q", ErrInvalidLabels, value, key)
+		}
+	}
+	return nil
+}
+
+// maxConfigLabels is the most labels a config may carry. Every label is
+// a label index entry written and deleted together with the config, so
+// this keeps the writes of one config well within maxTxnOps.
+const maxConfigLabels = 16
+
+// validateConfigLabels checks the labels of a config that is about to be
+// written.
+func validateConfigLabels(labels Labels) error {
+	if len(labels) > maxConfigLabels {
+		return fmt.Errorf("%w: %d labels, at most %d", ErrInvalidLabels, len(labels), maxConfigLabels)
+	}
+	return labels.Validate()
+}
+
+// labelReserved are the characters used by the canonical form, selectors
+// and store keys.
+const labelReserved = "/,=() \t\n"
+
+// String returns the canonical form: keys sorted, "k=v" pairs joined by
+// commas and keys with an empty value written alone.
+func (l Labels) String() string {
+	keys := make([]string, 0, len(l))
+	for key := range l {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var b strings.Builder
+	for i, key := range keys {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(key)
+		if l[key] != "" {
+			b.WriteByte('=')
+			b.WriteString(l[key])
+		}
+	}
+	return b.String()
+}
+
+// UnmarshalJSON accepts an object as well as the legacy string form.
+func (l *Labels) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		labels, err := ParseLabels(s)
+		if err != nil {
+			return err
+		}
+		*l = labels
+		return nil
+	}
+
+	labels := map[string]string{}
+	if err := json.Unmarshal(data, &labels); err != nil {
+		return err
+	}
+	if err := Labels(labels).Validate(); err != nil {
+		return err
+	}
+	*l = labels
+	return nil
+}
+
+type selectorOp int
+
+const (
+	opEquals selectorOp = iota
+	opNotEquals
+	opIn
+	opNotIn
+	opExists
+	opNotExists
+)
+
+type requirement struct {
+	key    string
+	op     selectorOp
+	values []string
+}
+
+func (r requirement) matches(labels Labels) bool {
+	value, ok := labels[r.key]
+	switch r.op {
+	case opEquals, opIn:
+		return ok && contains(r.values, value)
+	case opNotEquals, opNotIn:
+		return !ok || !contains(r.values, value)
+	case opExists:
+		return ok
+	case opNotExists:
+		return !ok
+	}
+	return false
+}
+
+// Selector matches labels against a list of requirements, all of which
+// have to hold. An empty selector matches everything.
+type Selector []requirement
+
+// ParseSelector parses a comma separated list of requirements:
+//
+//	env=prod, env==prod, env!=prod
+//	region in (eu,us), region notin (eu,us)
+//	canary, !canary
+func ParseSelector(s string) (Selector, error) {
+	selector := Selector{}
+	for _, item := range splitSelector(s) {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		r, err := parseRequirement(item)
+		if err != nil {
+			return nil, err
+		}
+		selector = append(selector, r)
+	}
+	return selector, nil
+}
+
+// Matches reports whether labels satisfy every requirement.
+func (s Selector) Matches(labels Labels) bool {
+	for _, r := range s {
+		if !r.matches(labels) {
+			return false
+		}
+	}
+	return true
+}
+
+// splitSelector splits at commas outside of parentheses.
+func splitSelector(s string) []string {
+	items := []string{}
+	depth, start := 0, 0
+	for i, c := range s {
+		switch c {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case ',':
+			if depth == 0 {
+				items = append(items, s[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(items, s[start:])
+}
+
+func parseRequirement(item string) (requirement, error) {
+	if open := strings.Index(item, "("); open >= 0 {
+		if !strings.HasSuffix(item, ")") {
+			return requirement{}, fmt.Errorf("%w %q: missing )", ErrInvalidSelector, item)
+		}
+		fields := strings.Fields(item[:open])
+		if len(fields) != 2 {
+			return requirement{}, fmt.Errorf("%w %q", ErrInvalidSelector, item)
+		}
+		r := requirement{key: fields[0]}
+		switch fields[1] {
+		case "in":
+			r.op = opIn
+		case "notin":
+			r.op = opNotIn
+		default:
+			return requirement{}, fmt.Errorf("%w %q: unknown operator %q", ErrInvalidSelector, item, fields[1])
+		}
+		for _, value := range strings.Split(item[open+1:len(item)-1], ",") {
+			r.values = append(r.values, strings.TrimSpace(value))
+		}
+		return r, validateRequirement(r, item)
+	}
+
+	var r requirement
+	switch {
+	case strings.HasPrefix(item, "!") && !strings.Contains(item, "="):
+		r = requirement{key: strings.TrimSpace(item[1:]), op: opNotExists}
+	case strings.Contains(item, "!="):
+		key, value, _ := strings.Cut(item, "!=")
+		r = requirement{key: strings.TrimSpace(key), op: opNotEquals, values: []string{strings.TrimSpace(value)}}
+	case strings.Contains(item, "=="):
+		key, value, _ := strings.Cut(item, "==")
+		r = requirement{key: strings.TrimSpace(key), op: opEquals, values: []string{strings.TrimSpace(value)}}
+	case strings.Contains(item, "="):
+		key, value, _ := strings.Cut(item, "=")
+		r = requirement{key: strings.TrimSpace(key), op: opEquals, values: []string{strings.TrimSpace(value)}}
+	default:
+		r = requirement{key: item, op: opExists}
+	}
+	return r, validateRequirement(r, item)
+}
+
+func validateRequirement(r requirement, item string) error {
+	if err := (Labels{r.key: ""}).Validate(); err != nil {
+		return fmt.Errorf("%w %q: %v", ErrInvalidSelector, item, err)
+	}
+	for _, value := range r.values {
+		if err := (Labels{r.key: value}).Validate(); err != nil {
+			return fmt.Errorf("%w %q: %v", ErrInvalidSelector, item, err)
+		}
+	}
+	return nil
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,254 @@
+package store
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// memoryKV keeps every pair in a map. It is meant for local development
+// and tests; nothing survives a restart.
+type memoryKV struct {
+	mu    sync.RWMutex
+	data  map[string]*kvPair
+	index uint64
+
+	// tombstones remembers the index deleted keys were removed at, so a
+	// blocking list notices deletes like Consul does. They are dropped
+	// after tombstoneTTL.
+	tombstones map[string]tombstone
+
+	// changed is closed and replaced on every write.
+	changed chan struct{}
+}
+
+type tombstone struct {
+	index   uint64
+	deleted time.Time
+}
+
+// NewMemory returns an in-process Store with the same key layout and
+// prefix semantics as the Consul one.
+func NewMemory() Store {
+	return &kvStore{
+		kv: &memoryKV{
+			data:       map[string]*kvPair{},
+			tombstones: map[string]tombstone{},
+			changed:    make(chan struct{}),
+		},
+	}
+}
+
+func (m *memoryKV) Get(ctx context.Context, key string) (*kvPair, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	pair, ok := m.data[key]
+	if !ok {
+		return nil, nil
+	}
+	return copyPair(pair), nil
+}
+
+func (m *memoryKV) List(ctx context.Context, prefix string) ([]*kvPair, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	pairs, _ := m.list(prefix)
+	return pairs, nil
+}
+
+func (m *memoryKV) Keys(ctx context.Context, prefix string) ([]string, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	keys := []string{}
+	for key := range m.data {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+func (m *memoryKV) GetMany(ctx context.Context, keys []string) ([]*kvPair, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	pairs := []*kvPair{}
+	for _, key := range keys {
+		if pair, ok := m.data[key]; ok {
+			pairs = append(pairs, copyPair(pair))
+		}
+	}
+	return pairs, nil
+}
+
+func (m *memoryKV) GetIndexes(ctx context.Context, keys []string) ([]*kvPair, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	pairs := []*kvPair{}
+	for _, key := range keys {
+		if pair, ok := m.data[key]; ok {
+			pairs = append(pairs, &kvPair{Key: key, Index: pair.Index, CreateIndex: pair.CreateIndex})
+		}
+	}
+	return pairs, nil
+}
+
+func (m *memoryKV) ListWait(ctx context.Context, prefix string, index uint64, wait time.Duration) ([]*kvPair, uint64, error) {
+	timeout := time.NewTimer(wait)
+	defer timeout.Stop()
+
+	for {
+		m.mu.RLock()
+		pairs, current := m.list(prefix)
+		changed := m.changed
+		m.mu.RUnlock()
+
+		if current > index {
+			return pairs, current, nil
+		}
+		select {
+		case <-changed:
+		case <-timeout.C:
+			return pairs, current, nil
+		case <-ctx.Done():
+			return nil, 0, ctx.Err()
+		}
+	}
+}
+
+func (m *memoryKV) Put(ctx context.Context, p *kvPair) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.set(p)
+	m.notify()
+	return nil
+}
+
+func (m *memoryKV) DeleteTree(ctx context.Context, prefix string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.deleteTree(prefix)
+	m.notify()
+	return nil
+}
+
+func (m *memoryKV) Txn(ctx context.Context, ops []*kvOp) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, op := range ops {
+		if op.Verb != kvCheckIndex {
+			continue
+		}
+		var index uint64
+		if pair, ok := m.data[op.Pair.Key]; ok {
+			index = pair.Index
+		}
+		if index != op.Pair.Index {
+			return false, nil
+		}
+	}
+
+	for _, op := range ops {
+		switch op.Verb {
+		case kvSet:
+			m.set(op.Pair)
+		case kvDeleteTree:
+			m.deleteTree(op.Pair.Key)
+		case kvDelete:
+			m.delete(op.Pair.Key)
+		}
+	}
+	m.notify()
+	return true, nil
+}
+
+// list returns the pairs under prefix and the highest index any of them,
+// or a deleted key under prefix, was written at.
+func (m *memoryKV) list(prefix string) ([]*kvPair, uint64) {
+	var index uint64
+	pairs := []*kvPair{}
+	for key, pair := range m.data {
+		if strings.HasPrefix(key, prefix) {
+			pairs = append(pairs, copyPair(pair))
+			if pair.Index > index {
+				index = pair.Index
+			}
+		}
+	}
+	for key, t := range m.tombstones {
+		if t.index > index && strings.HasPrefix(key, prefix) {
+			index = t.index
+		}
+	}
+	sort.Slice(pairs, func(i, j int) bool { return pairs[i].Key < pairs[j].Key })
+	return pairs, index
+}
+
+func (m *memoryKV) set(p *kvPair) {
+	m.index++
+	p.Index = m.index
+	p.CreateIndex = m.index
+	if old, ok := m.data[p.Key]; ok {
+		p.CreateIndex = old.CreateIndex
+	}
+	m.data[p.Key] = copyPair(p)
+	delete(m.tombstones, p.Key)
+}
+
+func (m *memoryKV) deleteTree(prefix string) {
+	m.index++
+	now := time.Now()
+	m.pruneTombstones(now)
+	for key := range m.data {
+		if strings.HasPrefix(key, prefix) {
+			delete(m.data, key)
+			m.tombstones[key] = tombstone{index: m.index, deleted: now}
+		}
+	}
+}
+
+func (m *memoryKV) delete(key string) {
+	if _, ok := m.data[key]; !ok {
+		return
+	}
+	m.index++
+	now := time.Now()
+	m.pruneTombstones(now)
+	delete(m.data, key)
+	m.tombstones[key] = tombstone{index: m.index, deleted: now}
+}
+
+// pruneTombstones drops the tombstones older than tombstoneTTL.
+func (m *memoryKV) pruneTombstones(now time.Time) {
+	for key, t := range m.tombstones {
+		if now.Sub(t.deleted) > tombstoneTTL {
+			delete(m.tombstones, key)
+		}
+	}
+}
+
+func (m *memoryKV) notify() {
+	close(m.changed)
+	m.changed = make(chan struct{})
+}
+
+func copyPair(p *kvPair) *kvPair {
+	return &kvPair{Key: p.Key, Value: copyBytes(p.Value), Index: p.Index, CreateIndex: p.CreateIndex}
+}
+
+func copyBytes(b []byte) []byte {
+	if b == nil {
+		return nil
+	}
+	return append([]byte{}, b...)
+}
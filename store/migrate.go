@@ -0,0 +1,171 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// openKVStore returns a Store over kv after moving what older releases
+// wrote under a version that is not in canonical form, see migrateVersions.
+func openKVStore(kv kv) (*kvStore, error) {
+	ps := &kvStore{kv: kv}
+	if err := ps.migrateVersions(context.Background()); err != nil {
+		return nil, fmt.Errorf("migrating versions: %w", err)
+	}
+	return ps, nil
+}
+
+// migrateVersions moves configs and groups stored under a version like v1
+// or 1.0, written before versions were normalized, to the key of the
+// canonical version 1.0.0, since lookups only build that key. Group copies
+// of such configs get the canonical version too. Once every key is
+// canonical it only lists the keys.
+func (ps *kvStore) migrateVersions(ctx context.Context) error {
+	groupKeys, err := ps.kv.Keys(ctx, allGroups+"/")
+	if err != nil {
+		return err
+	}
+	for _, key := range groupKeys {
+		if err := ps.migrateGroup(ctx, key); err != nil {
+			return err
+		}
+	}
+
+	configKeys, err := ps.kv.Keys(ctx, all+"/")
+	if err != nil {
+		return err
+	}
+	for _, key := range configKeys {
+		if err := ps.migrateConfig(ctx, key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// migrateGroup moves the group under key, groups/<id>/<version>/, to its
+// canonical key and normalizes the versions of its copies.
+func (ps *kvStore) migrateGroup(ctx context.Context, key string) error {
+	parts := strings.Split(key, "/")
+	if len(parts) != 4 {
+		return nil
+	}
+	pair, err := ps.kv.Get(ctx, key)
+	if err != nil || pair == nil {
+		return err
+	}
+	old, group := &Group{}, &Group{}
+	if err := json.Unmarshal(pair.Value, old); err != nil {
+		return err
+	}
+	if err := json.Unmarshal(pair.Value, group); err != nil {
+		return err
+	}
+
+	changed := false
+	for i := range group.Configs {
+		if version := normalizeVersion(group.Configs[i].Version); version != group.Configs[i].Version {
+			group.Configs[i].Version = version
+			changed = true
+		}
+	}
+	version := normalizeVersion(parts[2])
+	newKey := constructGroupKey(parts[1], version)
+	if !changed && newKey == key {
+		return nil
+	}
+	group.Version = version
+	value, err := json.Marshal(group)
+	if err != nil {
+		return err
+	}
+
+	ops := []*kvOp{{Verb: kvCheckIndex, Pair: &kvPair{Key: key, Index: pair.Index}}}
+	if newKey != key {
+		ops = append(ops,
+			&kvOp{Verb: kvDelete, Pair: &kvPair{Key: key}},
+			&kvOp{Verb: kvCheckIndex, Pair: &kvPair{Key: newKey}},
+		)
+		index := newGroupIndexTxn(ps.kv)
+		if err := index.update(ctx, key, old, nil); err != nil {
+			return err
+		}
+		if err := index.update(ctx, newKey, nil, group); err != nil {
+			return err
+		}
+		indexOps, err := index.ops()
+		if err != nil {
+			return err
+		}
+		ops = append(ops, indexOps...)
+	}
+	ops = append(ops, &kvOp{Verb: kvSet, Pair: &kvPair{Key: newKey, Value: value}})
+	return ps.migrateTxn(ctx, key, version, ops)
+}
+
+// migrateConfig moves the config under key, configs/<id>/<version> with
+// an optional /<labels>, and its label index entries to the canonical key.
+func (ps *kvStore) migrateConfig(ctx context.Context, key string) error {
+	parts := strings.Split(key, "/")
+	if len(parts) < 3 {
+		return nil
+	}
+	version := normalizeVersion(parts[2])
+	if version == parts[2] {
+		return nil
+	}
+	pair, err := ps.kv.Get(ctx, key)
+	if err != nil || pair == nil {
+		return err
+	}
+	old, config := &Config{}, &Config{}
+	if err := json.Unmarshal(pair.Value, old); err != nil {
+		return err
+	}
+	if err := json.Unmarshal(pair.Value, config); err != nil {
+		return err
+	}
+	// The index entries of old are keyed by the version it was stored
+	// under.
+	old.Version = parts[2]
+	config.Version = version
+	value, err := json.Marshal(config)
+	if err != nil {
+		return err
+	}
+
+	labels := ""
+	if len(parts) > 3 {
+		labels = strings.Join(parts[3:], "/")
+	}
+	newKey := constructKey(parts[1], version, labels)
+	ops := []*kvOp{
+		{Verb: kvCheckIndex, Pair: &kvPair{Key: key, Index: pair.Index}},
+		{Verb: kvDelete, Pair: &kvPair{Key: key}},
+		{Verb: kvCheckIndex, Pair: &kvPair{Key: newKey}},
+		{Verb: kvSet, Pair: &kvPair{Key: newKey, Value: value}},
+	}
+	for _, index := range constructLabelIndexKeys(old) {
+		ops = append(ops, &kvOp{Verb: kvDelete, Pair: &kvPair{Key: index}})
+	}
+	for _, index := range constructLabelIndexKeys(config) {
+		ops = append(ops, &kvOp{Verb: kvSet, Pair: &kvPair{Key: index, Value: []byte(newKey)}})
+	}
+	return ps.migrateTxn(ctx, key, version, ops)
+}
+
+// migrateTxn applies the ops moving key to version. It fails if the key
+// changed meanwhile or version is stored already, which needs a look by
+// hand.
+func (ps *kvStore) migrateTxn(ctx context.Context, key string, version string, ops []*kvOp) error {
+	ok, err := ps.kv.Txn(ctx, ops)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("%w: moving %s to version %s", ErrConflict, key, version)
+	}
+	return nil
+}
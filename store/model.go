@@ -10,13 +10,18 @@ type Config struct {
 	// in: map[string]string
 	Entries map[string]string `json:"entries"`
 
-	// Labels of the config
-	// in: string
-	Labels string `json:"labels"`
+	// Labels of the config, also accepted in the legacy "k=v,k2=v2" form
+	// in: map[string]string
+	Labels Labels `json:"labels"`
 
 	// Version of the config
 	// in: string
 	Version string `json:"version"`
+
+	Audit
+
+	// Modify index the config was read at, exposed as its ETag.
+	Index uint64 `json:"-"`
 }
 
 // swagger:model Group
@@ -32,9 +37,54 @@ type Group struct {
 	// Version of the group
 	// in: string
 	Version string `json:"version"`
-	/*
-		// Labels of the config
-		// in: string
-		Labels string `json:"labels"` //ne treba da ima labele
-	*/
+
+	// Labels of the group, also accepted in the legacy "k=v,k2=v2" form
+	// in: map[string]string
+	Labels Labels `json:"labels,omitempty"`
+
+	// How the group holds its configs: empty for embedded copies in
+	// Configs, "reference" for Refs resolved when the group is read
+	// in: string
+	Mode string `json:"mode,omitempty"`
+
+	// Configs of a reference mode group
+	// in: []ConfigRef
+	Refs []ConfigRef `json:"refs,omitempty"`
+
+	// Refs that no longer resolve to a config, only set on read
+	// in: []ConfigRef
+	Dangling []ConfigRef `json:"dangling,omitempty"`
+
+	Audit
+
+	// Modify index the group was read at, used to detect concurrent updates.
+	// Exposed to clients through the X-Modify-Index and ETag headers.
+	Index uint64 `json:"-"`
+}
+
+// ReferenceMode is the Mode of groups that hold config references.
+const ReferenceMode = "reference"
+
+// IsReference reports whether the group holds references instead of
+// copies of its configs.
+func (g *Group) IsReference() bool {
+	return g.Mode == ReferenceMode
+}
+
+// ConfigRef points at a config by id and version. Labels pick one of the
+// configs stored under that version, none means the one without labels.
+//
+// swagger:model ConfigRef
+type ConfigRef struct {
+	// Id of the config
+	// in: string
+	Id string `json:"id"`
+
+	// Version of the config, also latest or a range like ^1.2
+	// in: string
+	Version string `json:"version"`
+
+	// Labels of the config
+	// in: map[string]string
+	Labels Labels `json:"labels,omitempty"`
 }
@@ -0,0 +1,291 @@
+package store
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	tracer "example.com/mod/tracer"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// SortById orders by id, then version and labels, the order of the
+	// keys in the store.
+	SortById = "id"
+	// SortByVersion orders by semantic version, then like SortById.
+	SortByVersion = "version"
+	// SortByCreated orders by the store index the version was created at,
+	// oldest first.
+	SortByCreated = "created"
+)
+
+var (
+	// ErrInvalidCursor is returned for a cursor that was not handed out by
+	// a previous page, or for another sort order.
+	ErrInvalidCursor = errors.New("invalid cursor")
+
+	// ErrInvalidSort is returned for an unknown sort order.
+	ErrInvalidSort = errors.New("invalid sort")
+)
+
+// PageOptions selects one page of ListConfigs or ListGroups.
+type PageOptions struct {
+	// Limit is the most items on the page, it has to be positive.
+	Limit int
+	// Cursor continues after the last item of the page that returned it,
+	// empty for the first page.
+	Cursor string
+	// Sort is SortById, the default, SortByVersion or SortByCreated, with a
+	// leading - for descending order.
+	Sort string
+	// Selector only keeps the items whose labels match.
+	Selector Selector
+	// Labels only keeps the configs carrying all of them, looked up through
+	// the label index rather than listing every config.
+	Labels Labels
+	// UpdatedSince only keeps the items written at or after it, none when
+	// zero.
+	UpdatedSince time.Time
+}
+
+// pageEntry is what a listed key is ordered by.
+type pageEntry struct {
+	Key     string `json:"k"`
+	Created uint64 `json:"c,omitempty"`
+}
+
+// pageCursor is the last entry of a page and the order it was listed in.
+type pageCursor struct {
+	Sort string `json:"s,omitempty"`
+	pageEntry
+}
+
+// version is the version segment of configs/<id>/<version>/... and
+// groups/<id>/<version>/ keys.
+func (e pageEntry) version() string {
+	parts := strings.SplitN(e.Key, "/", 4)
+	if len(parts) < 3 {
+		return ""
+	}
+	return parts[2]
+}
+
+// pageOrder returns the less func of sort and whether it needs the create
+// index of every key.
+func pageOrder(order string) (func(a, b pageEntry) bool, bool, error) {
+	desc := strings.HasPrefix(order, "-")
+	var less func(a, b pageEntry) bool
+	switch strings.TrimPrefix(order, "-") {
+	case "", SortById:
+		less = func(a, b pageEntry) bool { return a.Key < b.Key }
+	case SortByVersion:
+		less = func(a, b pageEntry) bool {
+			if c := compareVersions(a.version(), b.version()); c != 0 {
+				return c < 0
+			}
+			return a.Key < b.Key
+		}
+	case SortByCreated:
+		less = func(a, b pageEntry) bool {
+			if a.Created != b.Created {
+				return a.Created < b.Created
+			}
+			return a.Key < b.Key
+		}
+	default:
+		return nil, false, fmt.Errorf("%w %q, expect %s, %s or %s", ErrInvalidSort, order, SortById, SortByVersion, SortByCreated)
+	}
+	created := strings.TrimPrefix(order, "-") == SortByCreated
+	if desc {
+		return func(a, b pageEntry) bool { return less(b, a) }, created, nil
+	}
+	return less, created, nil
+}
+
+func encodeCursor(order string, e pageEntry) string {
+	data, _ := json.Marshal(pageCursor{Sort: order, pageEntry: e})
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeCursor(order string, cursor string) (*pageEntry, error) {
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	c := &pageCursor{}
+	if err := json.Unmarshal(data, c); err != nil || c.Key == "" || c.Sort != order {
+		return nil, ErrInvalidCursor
+	}
+	return &c.pageEntry, nil
+}
+
+// page walks keys in the order of opts, starting after the cursor, and
+// reads their values in batches of opts.Limit. keep decodes a pair and
+// reports whether it made it onto the page. The returned cursor is empty on
+// the last page.
+//
+// Only the values of the visited keys are read. Ordering by creation reads
+// the create index of every key, but not their values.
+func (ps *kvStore) page(ctx context.Context, keys []string, opts PageOptions, keep func(pair *kvPair) (bool, error)) (string, error) {
+	span := tracer.StartSpanFromContext(ctx, "page")
+	defer span.Finish()
+
+	if opts.Limit <= 0 {
+		return "", errors.New("page limit has to be positive")
+	}
+	less, created, err := pageOrder(opts.Sort)
+	if err != nil {
+		return "", err
+	}
+	var after *pageEntry
+	if opts.Cursor != "" {
+		if after, err = decodeCursor(opts.Sort, opts.Cursor); err != nil {
+			return "", err
+		}
+	}
+
+	entries := []pageEntry{}
+	if created {
+		pairs, err := ps.kv.GetIndexes(ctx, keys)
+		if err != nil {
+			tracer.LogError(span, err)
+			return "", err
+		}
+		for _, pair := range pairs {
+			entries = append(entries, pageEntry{Key: pair.Key, Created: pair.CreateIndex})
+		}
+	} else {
+		for _, key := range keys {
+			entries = append(entries, pageEntry{Key: key})
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool { return less(entries[i], entries[j]) })
+
+	start := 0
+	if after != nil {
+		start = sort.Search(len(entries), func(i int) bool { return less(*after, entries[i]) })
+	}
+
+	found := 0
+	for i := start; i < len(entries); i += opts.Limit {
+		batch := entries[i:]
+		if len(batch) > opts.Limit {
+			batch = batch[:opts.Limit]
+		}
+		pairs, err := ps.pagePairs(ctx, batch)
+		if err != nil {
+			tracer.LogError(span, err)
+			return "", err
+		}
+		for j, entry := range batch {
+			pair := pairs[entry.Key]
+			if pair == nil {
+				continue
+			}
+			ok, err := keep(pair)
+			if err != nil {
+				tracer.LogError(span, err)
+				return "", err
+			}
+			if ok {
+				found++
+			}
+			if found == opts.Limit {
+				if i+j+1 < len(entries) {
+					return encodeCursor(opts.Sort, entry), nil
+				}
+				return "", nil
+			}
+		}
+	}
+	return "", nil
+}
+
+// pagePairs reads the pairs of batch, by key.
+func (ps *kvStore) pagePairs(ctx context.Context, batch []pageEntry) (map[string]*kvPair, error) {
+	pairs := map[string]*kvPair{}
+	keys := make([]string, 0, len(batch))
+	for _, entry := range batch {
+		keys = append(keys, entry.Key)
+	}
+	read, err := ps.kv.GetMany(ctx, keys)
+	if err != nil {
+		return nil, err
+	}
+	for _, pair := range read {
+		pairs[pair.Key] = pair
+	}
+	return pairs, nil
+}
+
+func (ps *kvStore) ListConfigs(ctx context.Context, opts PageOptions) ([]*Config, string, error) {
+	span := tracer.StartSpanFromContext(ctx, "ListConfigs")
+	defer span.Finish()
+
+	var keys []string
+	var err error
+	if len(opts.Labels) > 0 {
+		keys, err = ps.labelIndexKeys(ctx, opts.Labels)
+	} else {
+		keys, err = ps.kv.Keys(ctx, all+"/")
+	}
+	if err != nil {
+		tracer.LogError(span, err)
+		return nil, "", err
+	}
+
+	configs := []*Config{}
+	next, err := ps.page(ctx, keys, opts, func(pair *kvPair) (bool, error) {
+		config := &Config{}
+		if err := json.Unmarshal(pair.Value, config); err != nil {
+			return false, err
+		}
+		if !opts.Selector.Matches(config.Labels) ||
+			!opts.UpdatedSince.IsZero() && !config.UpdatedSince(opts.UpdatedSince) {
+			return false, nil
+		}
+		config.Index = pair.Index
+		configs = append(configs, config)
+		return true, nil
+	})
+	if err != nil {
+		tracer.LogError(span, err)
+		return nil, "", err
+	}
+	return configs, next, nil
+}
+
+func (ps *kvStore) ListGroups(ctx context.Context, opts PageOptions) ([]*Group, string, error) {
+	span := tracer.StartSpanFromContext(ctx, "ListGroups")
+	defer span.Finish()
+
+	keys, err := ps.kv.Keys(ctx, allGroups+"/")
+	if err != nil {
+		tracer.LogError(span, err)
+		return nil, "", err
+	}
+
+	groups := []*Group{}
+	next, err := ps.page(ctx, keys, opts, func(pair *kvPair) (bool, error) {
+		group := &Group{}
+		if err := json.Unmarshal(pair.Value, group); err != nil {
+			return false, err
+		}
+		if !opts.Selector.Matches(group.Labels) ||
+			!opts.UpdatedSince.IsZero() && !group.UpdatedSince(opts.UpdatedSince) {
+			return false, nil
+		}
+		group.Index = pair.Index
+		groups = append(groups, group)
+		return true, nil
+	})
+	if err != nil {
+		tracer.LogError(span, err)
+		return nil, "", err
+	}
+	return groups, next, nil
+}
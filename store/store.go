@@ -6,41 +6,278 @@ import (
 	"errors"
 	tracer "example.com/mod/tracer"
 	"fmt"
-	"github.com/google/uuid"
-	"github.com/hashicorp/consul/api"
+	"io"
 	"os"
+	"time"
 )
 
-type Store struct {
-	cli *api.Client
+// ConfigStore holds configs under configs/<id>/<version>/<labels>.
+type ConfigStore interface {
+	Get(ctx context.Context, id string, version string) ([]*Config, error)
+	GetAll(ctx context.Context) ([]*Config, error)
+	// ListConfigs returns one page of the configs of all ids and the
+	// cursor of the next page, empty on the last one.
+	ListConfigs(ctx context.Context, opts PageOptions) ([]*Config, string, error)
+	GetOneConfig(ctx context.Context, id string, version string) (*Config, error)
+	GetOneConfig2(ctx context.Context, id string) (*Config, error)
+	// GetConfigsByLabels matches labels exactly, in any order.
+	GetConfigsByLabels(ctx context.Context, id string, version string, labels string) ([]*Config, error)
+	// GetConfigsBySelector returns the configs whose labels match selector,
+	// of all ids for an empty id and of all versions for an empty version.
+	GetConfigsBySelector(ctx context.Context, id string, version string, selector Selector) ([]*Config, error)
+	// FindConfigsByLabels returns the configs of any id and version that
+	// carry all of labels, looked up through the label index.
+	FindConfigsByLabels(ctx context.Context, labels Labels) ([]*Config, error)
+	Config(ctx context.Context, config *Config) (*Config, error)
+	// NewConfigVersion publishes config.Version under the existing
+	// config.Id. It returns ErrConfigNotFound for an unknown id and
+	// ErrVersionExists if the version was published before.
+	NewConfigVersion(ctx context.Context, config *Config) (*Config, error)
+	// UpdateDraft replaces the draft old, as read at old.Index, with config.
+	// Labels may change, id and version may not. It returns
+	// ErrVersionExists if old is not a draft and ErrConflict if it changed
+	// since it was read.
+	UpdateDraft(ctx context.Context, old *Config, config *Config) (*Config, error)
+	// Delete and DeleteByLabel fail with a ReferencedError while groups
+	// hold the configs and with ErrConfigNotFound if there are none. With cascade the configs are removed from those
+	// groups instead, in the same transaction, and the changed groups are
+	// returned.
+	Delete(ctx context.Context, id string, version string, cascade bool) (map[string]string, []*Group, error)
+	DeleteByLabel(ctx context.Context, id string, version string, labels string, cascade bool) (map[string]string, []*Group, error)
+	// DeleteConfigs is Delete for configs as read, it fails with
+	// ErrConflict if any of them changed since. Deletes guarded by If-Match
+	// use it, so the versions that matched are the ones deleted.
+	DeleteConfigs(ctx context.Context, configs []*Config, cascade bool) ([]*Group, error)
+	// ConfigVersions lists the versions of a config id, lowest first.
+	ConfigVersions(ctx context.Context, id string) ([]string, error)
+	// ResolveConfigVersion turns latest or a range like ^1.2 into the
+	// highest matching stored version. Exact versions are returned in the
+	// form they are stored in, so v1.2 is 1.2.0.
+	ResolveConfigVersion(ctx context.Context, id string, version string) (string, error)
 }
 
-func New() (*Store, error) {
-	db := os.Getenv("DB")
-	dbport := os.Getenv("DBPORT")
+// GroupStore holds groups under groups/<id>/<version>/.
+type GroupStore interface {
+	GetGroup(ctx context.Context, id string, version string) ([]*Group, error)
+	GetGroupId(ctx context.Context, id string) ([]*Group, error)
+	GetOneGroup(ctx context.Context, id string, version string) (*Group, error)
+	GetOneGroup2(ctx context.Context, id string) (*Group, error)
+	GetAllGroups(ctx context.Context) ([]*Group, error)
+	// ListGroups is ListConfigs for groups, opts.Labels is ignored.
+	ListGroups(ctx context.Context, opts PageOptions) ([]*Group, string, error)
+	// SaveGroup writes the group only if it was not modified since it was
+	// read at post.Index, otherwise it returns ErrConflict. A group that was
+	// not read, Index 0, is never overwritten: ErrVersionExists.
+	SaveGroup(ctx context.Context, post *Group) (*Group, error)
+	PostGroup(ctx context.Context, post *Group) (*Group, error)
+	// NewGroupVersion publishes post.Version under the existing post.Id.
+	// It returns ErrGroupNotFound for an unknown id and ErrVersionExists if
+	// the version was published before.
+	NewGroupVersion(ctx context.Context, post *Group) (*Group, error)
+	DeleteGroup(ctx context.Context, id string, version string) (map[string]string, error)
+	DeleteGroupId(ctx context.Context, id string) (map[string]string, error)
+	// DeleteGroups is DeleteConfigs for groups.
+	DeleteGroups(ctx context.Context, groups []*Group) error
+	// GetGroupsByLabels matches labels exactly, in any order.
+	GetGroupsByLabels(ctx context.Context, id string, version string, labels string) ([]*Group, error)
+	// GetGroupsBySelector returns the groups whose labels match selector,
+	// of all ids for an empty id and of all versions for an empty version.
+	GetGroupsBySelector(ctx context.Context, id string, version string, selector Selector) ([]*Group, error)
+	// DeleteGroupByLabels deletes the group only if its labels match.
+	DeleteGroupByLabels(ctx context.Context, id string, version string, labels string) (map[string]string, error)
+	// GroupVersions lists the versions of a group id, lowest first.
+	GroupVersions(ctx context.Context, id string) ([]string, error)
+	// ResolveGroupVersion is ResolveConfigVersion for groups.
+	ResolveGroupVersion(ctx context.Context, id string, version string) (string, error)
+}
+
+// RequestStore remembers the responses to requests with an idempotency
+// key, under idempotency/<scope>/<key>, until they expire.
+type RequestStore interface {
+	// ReserveIdempotencyKey claims key within scope for a request with the
+	// given fingerprint. It returns the modify index of the claim, or the
+	// stored response of an earlier request with the key, which has Status
+	// 0 while that request is still being handled. The claim lasts until
+	// expires, a short lease the request renews while it runs and
+	// CompleteIdempotencyKey replaces with the full TTL; an expired claim
+	// is taken over. A concurrent claim fails with ErrConflict.
+	ReserveIdempotencyKey(ctx context.Context, scope string, key string, fingerprint string, expires time.Time) (*IdempotentResponse, uint64, error)
+	// RenewIdempotencyKey extends the lease of a claim until expires and
+	// returns the new modify index of the claim. It, like Complete and
+	// Release, fails with ErrConflict once the claim was taken over; the
+	// key then belongs to the request that took it.
+	RenewIdempotencyKey(ctx context.Context, scope string, key string, claim uint64, fingerprint string, expires time.Time) (uint64, error)
+	// CompleteIdempotencyKey stores the response of a claimed key.
+	CompleteIdempotencyKey(ctx context.Context, scope string, key string, claim uint64, response *IdempotentResponse) error
+	// ReleaseIdempotencyKey drops a claimed key, so the request can be
+	// retried with it.
+	ReleaseIdempotencyKey(ctx context.Context, scope string, key string, claim uint64) error
+	// ExpireIdempotencyKeys drops the keys that expired before now and
+	// returns how many.
+	ExpireIdempotencyKeys(ctx context.Context, now time.Time) (int, error)
+}
+
+// Watcher notifies about changes of stored configs and groups. An empty
+// id watches all of them.
+type Watcher interface {
+	WatchConfigs(ctx context.Context, id string) (<-chan struct{}, error)
+	WatchGroups(ctx context.Context, id string) (<-chan struct{}, error)
+
+	// WaitConfigs is Get as a blocking query: it returns once the configs
+	// changed after index or wait elapsed, along with the index to wait on
+	// next.
+	WaitConfigs(ctx context.Context, id string, version string, index uint64, wait time.Duration) ([]*Config, uint64, error)
+
+	// WaitGroups is GetGroup, or GetGroupId for an empty version, as a
+	// blocking query.
+	WaitGroups(ctx context.Context, id string, version string, index uint64, wait time.Duration) ([]*Group, uint64, error)
+}
+
+var (
+	// ErrConflict is returned when a write loses against a concurrent one,
+	// e.g. by SaveGroup when the group was changed since it was read.
+	ErrConflict = errors.New("resource was modified concurrently")
+
+	// ErrNotFound is returned when the config or group id does not exist.
+	ErrNotFound = errors.New("not found")
+
+	// ErrConfigNotFound and ErrGroupNotFound tell which of the two is
+	// missing, both are also ErrNotFound.
+	ErrConfigNotFound = fmt.Errorf("config %w", ErrNotFound)
+	ErrGroupNotFound  = fmt.Errorf("group %w", ErrNotFound)
+
+	// ErrVersionExists is returned when publishing a version of a config or
+	// group that already exists. Published versions are immutable.
+	ErrVersionExists = errors.New("version already exists")
+
+	// ErrWatchNotSupported is returned by backends that cannot push changes.
+	ErrWatchNotSupported = errors.New("store backend does not support watch")
+)
+
+// Store is everything the config server needs from its storage backend.
+type Store interface {
+	ConfigStore
+	GroupStore
+	RequestStore
+	Watcher
+	io.Closer
+}
+
+// New returns the Store selected by the STORE env var. Consul is used
+// when it is not set.
+func New() (Store, error) {
+	switch backend := os.Getenv("STORE"); backend {
+	case "", "consul":
+		return NewConsul()
+	case "memory":
+		return NewMemory(), nil
+	case "bolt":
+		return NewBolt("")
+	case "etcd":
+		return NewEtcd()
+	default:
+		return nil, fmt.Errorf("unknown store backend %q", backend)
+	}
+}
+
+// kvStore implements Store on top of any kv backend.
+type kvStore struct {
+	kv kv
+}
+
+func (ps *kvStore) WatchConfigs(ctx context.Context, id string) (<-chan struct{}, error) {
+	if id == "" {
+		return ps.watch(ctx, all)
+	}
+	return ps.watch(ctx, constructKey2(id)+"/")
+}
+
+func (ps *kvStore) WatchGroups(ctx context.Context, id string) (<-chan struct{}, error) {
+	if id == "" {
+		return ps.watch(ctx, allGroups)
+	}
+	return ps.watch(ctx, constructGroupKey2(id))
+}
+
+func (ps *kvStore) WaitConfigs(ctx context.Context, id string, version string, index uint64, wait time.Duration) ([]*Config, uint64, error) {
+	span := tracer.StartSpanFromContext(ctx, "WaitConfigs")
+	defer span.Finish()
 
-	config := api.DefaultConfig()
-	config.Address = fmt.Sprintf("%s:%s", db, dbport)
-	client, err := api.NewClient(config)
+	data, current, err := ps.kv.ListWait(ctx, constructKey(id, version, ""), index, wait)
 	if err != nil {
-		return nil, err
+		tracer.LogError(span, err)
+		return nil, 0, err
 	}
+	data = versionPairs(data, id, version)
 
-	return &Store{
-		cli: client,
-	}, nil
+	configs := []*Config{}
+	for _, pair := range data {
+		config := &Config{}
+		err = json.Unmarshal(pair.Value, config)
+		if err != nil {
+			tracer.LogError(span, err)
+			return nil, 0, err
+		}
+		config.Index = pair.Index
+		configs = append(configs, config)
+	}
+	return configs, current, nil
 }
 
-func (ps *Store) Get(ctx context.Context, id string, version string) ([]*Config, error) {
+func (ps *kvStore) WaitGroups(ctx context.Context, id string, version string, index uint64, wait time.Duration) ([]*Group, uint64, error) {
+	span := tracer.StartSpanFromContext(ctx, "WaitGroups")
+	defer span.Finish()
+
+	prefix := constructGroupKey2(id)
+	if version != "" {
+		prefix = constructGroupKey(id, version)
+	}
+	data, current, err := ps.kv.ListWait(ctx, prefix, index, wait)
+	if err != nil {
+		tracer.LogError(span, err)
+		return nil, 0, err
+	}
+
+	groups := []*Group{}
+	for _, pair := range data {
+		group := &Group{}
+		err = json.Unmarshal(pair.Value, group)
+		if err != nil {
+			tracer.LogError(span, err)
+			return nil, 0, err
+		}
+		group.Index = pair.Index
+		groups = append(groups, group)
+	}
+	return groups, current, nil
+}
+
+func (ps *kvStore) watch(ctx context.Context, prefix string) (<-chan struct{}, error) {
+	w, ok := ps.kv.(kvWatcher)
+	if !ok {
+		return nil, ErrWatchNotSupported
+	}
+	return w.Watch(ctx, prefix), nil
+}
+
+// Close releases the backend if it holds any resources.
+func (ps *kvStore) Close() error {
+	if c, ok := ps.kv.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+func (ps *kvStore) Get(ctx context.Context, id string, version string) ([]*Config, error) {
 	span := tracer.StartSpanFromContext(ctx, "GetConfig")
 	defer span.Finish()
-	kv := ps.cli.KV()
 
-	data, _, err := kv.List(constructKey(id, version, ""), nil)
+	data, err := ps.kv.List(ctx, constructKey(id, version, ""))
 	if err != nil {
 		tracer.LogError(span, err)
 		return nil, err
 	}
+	data = versionPairs(data, id, version)
 
 	configs := []*Config{}
 	for _, pair := range data {
@@ -50,17 +287,16 @@ func (ps *Store) Get(ctx context.Context, id string, version string) ([]*Config,
 			tracer.LogError(span, err)
 			return nil, err
 		}
+		config.Index = pair.Index
 		configs = append(configs, config)
 	}
 	return configs, nil
 }
-func (ps *Store) GetGroup(ctx context.Context, id string, version string) ([]*Group, error) {
+func (ps *kvStore) GetGroup(ctx context.Context, id string, version string) ([]*Group, error) {
 	span := tracer.StartSpanFromContext(ctx, "GetGroup")
 	defer span.Finish()
 
-	kv := ps.cli.KV()
-
-	data, _, err := kv.List(constructGroupKey(id, version), nil)
+	data, err := ps.kv.List(ctx, constructGroupKey(id, version))
 	if err != nil {
 		tracer.LogError(span, err)
 		return nil, err
@@ -74,17 +310,16 @@ func (ps *Store) GetGroup(ctx context.Context, id string, version string) ([]*Gr
 			tracer.LogError(span, err)
 			return nil, err
 		}
+		config.Index = pair.Index
 		configs = append(configs, config)
 	}
 	return configs, nil
 }
-func (ps *Store) GetGroupId(ctx context.Context, id string) ([]*Group, error) {
+func (ps *kvStore) GetGroupId(ctx context.Context, id string) ([]*Group, error) {
 	span := tracer.StartSpanFromContext(ctx, "GetGroup")
 	defer span.Finish()
 
-	kv := ps.cli.KV()
-
-	data, _, err := kv.List(constructGroupKey2(id), nil)
+	data, err := ps.kv.List(ctx, constructGroupKey2(id))
 	if err != nil {
 		tracer.LogError(span, err)
 		return nil, err
@@ -98,16 +333,16 @@ func (ps *Store) GetGroupId(ctx context.Context, id string) ([]*Group, error) {
 			tracer.LogError(span, err)
 			return nil, err
 		}
+		config.Index = pair.Index
 		configs = append(configs, config)
 	}
 	return configs, nil
 }
-func (ps *Store) GetOneGroup(ctx context.Context, id string, version string) (*Group, error) {
+func (ps *kvStore) GetOneGroup(ctx context.Context, id string, version string) (*Group, error) {
 	span := tracer.StartSpanFromContext(ctx, "GetOneGroup")
 	defer span.Finish()
-	kv := ps.cli.KV()
 
-	data, _, err := kv.List(constructGroupKey(id, version), nil)
+	data, err := ps.kv.List(ctx, constructGroupKey(id, version))
 	if err != nil {
 		tracer.LogError(span, err)
 		return nil, err
@@ -120,18 +355,17 @@ func (ps *Store) GetOneGroup(ctx context.Context, id string, version string) (*G
 			tracer.LogError(span, err)
 			return nil, err
 		}
+		config.Index = pair.Index
 		return config, nil
 	}
 
-	// Ako nijedna grupa nije pronađena, možete vratiti odgovarajuću grešku
-	return nil, errors.New("group not found, not exist")
+	return nil, ErrGroupNotFound
 }
-func (ps *Store) GetOneGroup2(ctx context.Context, id string) (*Group, error) {
+func (ps *kvStore) GetOneGroup2(ctx context.Context, id string) (*Group, error) {
 	span := tracer.StartSpanFromContext(ctx, "GetOneGroup")
 	defer span.Finish()
-	kv := ps.cli.KV()
 
-	data, _, err := kv.List(constructGroupKey2(id), nil)
+	data, err := ps.kv.List(ctx, constructGroupKey2(id))
 	if err != nil {
 		tracer.LogError(span, err)
 		return nil, err
@@ -144,22 +378,22 @@ func (ps *Store) GetOneGroup2(ctx context.Context, id string) (*Group, error) {
 			tracer.LogError(span, err)
 			return nil, err
 		}
+		config.Index = pair.Index
 		return config, nil
 	}
 
-	// Ako nijedna grupa nije pronađena, možete vratiti odgovarajuću grešku
-	return nil, errors.New("group not found, not exist")
+	return nil, ErrGroupNotFound
 }
-func (ps *Store) GetOneConfig(ctx context.Context, id string, version string) (*Config, error) {
+func (ps *kvStore) GetOneConfig(ctx context.Context, id string, version string) (*Config, error) {
 	span := tracer.StartSpanFromContext(ctx, "GetOneConfig")
 	defer span.Finish()
-	kv := ps.cli.KV()
 
-	data, _, err := kv.List(constructKey(id, version, ""), nil)
+	data, err := ps.kv.List(ctx, constructKey(id, version, ""))
 	if err != nil {
 		tracer.LogError(span, err)
 		return nil, err
 	}
+	data = versionPairs(data, id, version)
 
 	for _, pair := range data {
 		config := &Config{}
@@ -168,18 +402,17 @@ func (ps *Store) GetOneConfig(ctx context.Context, id string, version string) (*
 			tracer.LogError(span, err)
 			return nil, err
 		}
+		config.Index = pair.Index
 		return config, nil
 	}
 
-	// Ako nijedna grupa nije pronađena, možete vratiti odgovarajuću grešku
-	return nil, errors.New("config not found, not exist")
+	return nil, ErrConfigNotFound
 }
-func (ps *Store) GetOneConfig2(ctx context.Context, id string) (*Config, error) {
+func (ps *kvStore) GetOneConfig2(ctx context.Context, id string) (*Config, error) {
 	span := tracer.StartSpanFromContext(ctx, "GetOneConfig")
 	defer span.Finish()
-	kv := ps.cli.KV()
 
-	data, _, err := kv.List(constructKey2(id), nil)
+	data, err := ps.kv.List(ctx, constructKey2(id)+"/")
 	if err != nil {
 		tracer.LogError(span, err)
 		return nil, err
@@ -192,42 +425,82 @@ func (ps *Store) GetOneConfig2(ctx context.Context, id string) (*Config, error)
 			tracer.LogError(span, err)
 			return nil, err
 		}
+		config.Index = pair.Index
 		return config, nil
 	}
 
-	// Ako nijedna grupa nije pronađena, možete vratiti odgovarajuću grešku
-	return nil, errors.New("config not found, not exist")
+	return nil, ErrConfigNotFound
 }
 
-func (ps *Store) SaveGroup(ctx context.Context, post *Group) (*Group, error) {
+func (ps *kvStore) SaveGroup(ctx context.Context, post *Group) (*Group, error) {
 	span := tracer.StartSpanFromContext(ctx, "SaveGroup")
 	defer span.Finish()
-	kv := ps.cli.KV()
 
 	/*sid, rid := generateGroupKey(post.Version, post.Labels)
 	post.Id = rid
 	*/
+	key := constructGroupKey(post.Id, post.Version)
+	stored, err := ps.kv.Get(ctx, key)
+	if err != nil {
+		tracer.LogError(span, err)
+		return nil, err
+	}
+	if stored != nil && post.Index == 0 {
+		// Nothing was read, so this was an attempt to overwrite.
+		tracer.LogError(span, ErrVersionExists)
+		return nil, ErrVersionExists
+	}
+	var old *Group
+	if stored != nil {
+		old = &Group{}
+		if err := json.Unmarshal(stored.Value, old); err != nil {
+			tracer.LogError(span, err)
+			return nil, err
+		}
+		post.Audit = old.Audit.updatedAudit(ctx)
+	} else {
+		post.Audit = createdAudit(ctx)
+	}
 	data, err := json.Marshal(post)
 	if err != nil {
 		tracer.LogError(span, err)
 		return nil, err
 	}
 
-	p := &api.KVPair{Key: constructGroupKey(post.Id, post.Version), Value: data}
-	_, err = kv.Put(p, nil)
+	p := &kvPair{Key: key, Value: data}
+	ops := []*kvOp{
+		{Verb: kvCheckIndex, Pair: &kvPair{Key: key, Index: post.Index}},
+		{Verb: kvSet, Pair: p},
+	}
+	indexOps, err := ps.groupIndexOps(ctx, key, old, post)
 	if err != nil {
 		tracer.LogError(span, err)
 		return nil, err
 	}
+	checks, err := ps.memberChecks(ctx, old, post)
+	if err != nil {
+		tracer.LogError(span, err)
+		return nil, err
+	}
+	ops = append(ops, checks...)
+	ok, err := ps.kv.Txn(ctx, append(ops, indexOps...))
+	if err != nil {
+		tracer.LogError(span, err)
+		return nil, err
+	}
+	if !ok {
+		tracer.LogError(span, ErrConflict)
+		return nil, ErrConflict
+	}
 
+	post.Index = p.Index
 	return post, nil
 }
 
-func (ps *Store) GetAll(ctx context.Context) ([]*Config, error) {
+func (ps *kvStore) GetAll(ctx context.Context) ([]*Config, error) {
 	span := tracer.StartSpanFromContext(ctx, "GetAll")
 	defer span.Finish()
-	kv := ps.cli.KV()
-	data, _, err := kv.List(all, nil)
+	data, err := ps.kv.List(ctx, all)
 	if err != nil {
 		tracer.LogError(span, err)
 		return nil, err
@@ -241,16 +514,16 @@ func (ps *Store) GetAll(ctx context.Context) ([]*Config, error) {
 			tracer.LogError(span, err)
 			return nil, err
 		}
+		config.Index = pair.Index
 		configs = append(configs, config)
 	}
 
 	return configs, nil
 }
-func (ps *Store) GetAllGroups(ctx context.Context) ([]*Group, error) {
+func (ps *kvStore) GetAllGroups(ctx context.Context) ([]*Group, error) {
 	span := tracer.StartSpanFromContext(ctx, "GetAllGroups")
 	defer span.Finish()
-	kv := ps.cli.KV()
-	data, _, err := kv.List(allGroups, nil)
+	data, err := ps.kv.List(ctx, allGroups)
 	if err != nil {
 		tracer.LogError(span, err)
 		return nil, err
@@ -264,41 +537,71 @@ func (ps *Store) GetAllGroups(ctx context.Context) ([]*Group, error) {
 			tracer.LogError(span, err)
 			return nil, err
 		}
+		group.Index = pair.Index
 		groups = append(groups, group)
 	}
 
 	return groups, nil
 }
 
-func (ps *Store) Delete(ctx context.Context, id string, version string) (map[string]string, error) {
+func (ps *kvStore) Delete(ctx context.Context, id string, version string, cascade bool) (map[string]string, []*Group, error) {
 	span := tracer.StartSpanFromContext(ctx, "Delete")
 	defer span.Finish()
-	kv := ps.cli.KV()
-	_, err := kv.DeleteTree(constructKey(id, version, ""), nil)
+	data, err := ps.kv.List(ctx, constructKey(id, version, ""))
 	if err != nil {
 		tracer.LogError(span, err)
-		return nil, err
+		return nil, nil, err
+	}
+	configs, err := pairConfigs(versionPairs(data, id, version))
+	if err != nil {
+		tracer.LogError(span, err)
+		return nil, nil, err
+	}
+	if len(configs) == 0 {
+		tracer.LogError(span, ErrConfigNotFound)
+		return nil, nil, ErrConfigNotFound
+	}
+	groups, err := ps.deleteConfigs(ctx, configs, cascade)
+	if err != nil {
+		tracer.LogError(span, err)
+		return nil, nil, err
 	}
 
-	return map[string]string{"Deleted": id}, nil
+	return map[string]string{"Deleted": id}, groups, nil
 }
-func (ps *Store) DeleteByLabel(ctx context.Context, id string, version string, labels string) (map[string]string, error) {
+func (ps *kvStore) DeleteByLabel(ctx context.Context, id string, version string, labels string, cascade bool) (map[string]string, []*Group, error) {
 	span := tracer.StartSpanFromContext(ctx, "Delete")
 	defer span.Finish()
-	kv := ps.cli.KV()
-	_, err := kv.DeleteTree(constructKey(id, version, labels), nil)
+	configs, err := ps.GetConfigsByLabels(ctx, id, version, labels)
 	if err != nil {
 		tracer.LogError(span, err)
-		return nil, err
+		return nil, nil, err
+	}
+	if len(configs) == 0 {
+		tracer.LogError(span, ErrConfigNotFound)
+		return nil, nil, ErrConfigNotFound
+	}
+	groups, err := ps.deleteConfigs(ctx, configs, cascade)
+	if err != nil {
+		tracer.LogError(span, err)
+		return nil, nil, err
 	}
 
-	return map[string]string{"Deleted": id}, nil
+	return map[string]string{"Deleted": id}, groups, nil
 }
-func (ps *Store) DeleteGroup(ctx context.Context, id string, version string) (map[string]string, error) {
+func (ps *kvStore) DeleteGroup(ctx context.Context, id string, version string) (map[string]string, error) {
 	span := tracer.StartSpanFromContext(ctx, "DeleteGroup")
 	defer span.Finish()
-	kv := ps.cli.KV()
-	_, err := kv.DeleteTree(constructGroupKey(id, version), nil)
+	groups, err := ps.GetGroup(ctx, id, version)
+	if err != nil {
+		tracer.LogError(span, err)
+		return nil, err
+	}
+	if len(groups) == 0 {
+		tracer.LogError(span, ErrGroupNotFound)
+		return nil, ErrGroupNotFound
+	}
+	err = ps.deleteGroups(ctx, groups)
 	if err != nil {
 		tracer.LogError(span, err)
 		return nil, err
@@ -307,11 +610,19 @@ func (ps *Store) DeleteGroup(ctx context.Context, id string, version string) (ma
 	return map[string]string{"Deleted": id}, nil
 }
 
-func (ps *Store) DeleteGroupId(ctx context.Context, id string) (map[string]string, error) {
+func (ps *kvStore) DeleteGroupId(ctx context.Context, id string) (map[string]string, error) {
 	span := tracer.StartSpanFromContext(ctx, "DeleteGroup")
 	defer span.Finish()
-	kv := ps.cli.KV()
-	_, err := kv.DeleteTree(constructGroupKey2(id), nil)
+	groups, err := ps.GetGroupId(ctx, id)
+	if err != nil {
+		tracer.LogError(span, err)
+		return nil, err
+	}
+	if len(groups) == 0 {
+		tracer.LogError(span, ErrGroupNotFound)
+		return nil, ErrGroupNotFound
+	}
+	err = ps.deleteGroups(ctx, groups)
 	if err != nil {
 		tracer.LogError(span, err)
 		return nil, err
@@ -320,39 +631,434 @@ func (ps *Store) DeleteGroupId(ctx context.Context, id string) (map[string]strin
 	return map[string]string{"Deleted": id}, nil
 }
 
-func (ps *Store) Config(ctx context.Context, config *Config) (*Config, error) {
+func (ps *kvStore) DeleteConfigs(ctx context.Context, configs []*Config, cascade bool) ([]*Group, error) {
+	span := tracer.StartSpanFromContext(ctx, "DeleteConfigs")
+	defer span.Finish()
+
+	if len(configs) == 0 {
+		tracer.LogError(span, ErrConfigNotFound)
+		return nil, ErrConfigNotFound
+	}
+	groups, err := ps.deleteConfigs(ctx, configs, cascade)
+	if err != nil {
+		tracer.LogError(span, err)
+		return nil, err
+	}
+	return groups, nil
+}
+
+func (ps *kvStore) DeleteGroups(ctx context.Context, groups []*Group) error {
+	span := tracer.StartSpanFromContext(ctx, "DeleteGroups")
+	defer span.Finish()
+
+	if len(groups) == 0 {
+		tracer.LogError(span, ErrGroupNotFound)
+		return ErrGroupNotFound
+	}
+	if err := ps.deleteGroups(ctx, groups); err != nil {
+		tracer.LogError(span, err)
+		return err
+	}
+	return nil
+}
+
+// deleteGroups removes the groups, as read, and their reverse index
+// entries. It fails with ErrConflict if any of them changed since read.
+func (ps *kvStore) deleteGroups(ctx context.Context, groups []*Group) error {
+	index := newGroupIndexTxn(ps.kv)
+	ops := []*kvOp{}
+	for _, group := range groups {
+		key := constructGroupKey(group.Id, group.Version)
+		ops = append(ops,
+			&kvOp{Verb: kvCheckIndex, Pair: &kvPair{Key: key, Index: group.Index}},
+			&kvOp{Verb: kvDelete, Pair: &kvPair{Key: key}},
+		)
+		if err := index.update(ctx, key, group, nil); err != nil {
+			return err
+		}
+	}
+	if len(ops) == 0 {
+		return nil
+	}
+	indexOps, err := index.ops()
+	if err != nil {
+		return err
+	}
+
+	ok, err := ps.kv.Txn(ctx, append(ops, indexOps...))
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrConflict
+	}
+	return nil
+}
+
+// groupIndexOps are the reverse index ops of writing group under key over
+// old, which is nil for a new group.
+func (ps *kvStore) groupIndexOps(ctx context.Context, key string, old *Group, group *Group) ([]*kvOp, error) {
+	index := newGroupIndexTxn(ps.kv)
+	if err := index.update(ctx, key, old, group); err != nil {
+		return nil, err
+	}
+	return index.ops()
+}
+
+func (ps *kvStore) Config(ctx context.Context, config *Config) (*Config, error) {
 	span := tracer.StartSpanFromContext(ctx, "Config")
 	defer span.Finish()
 
-	kv := ps.cli.KV()
+	if err := ValidateVersion(config.Version); err != nil {
+		tracer.LogError(span, err)
+		return nil, err
+	}
+	config.Version = normalizeVersion(config.Version)
 
 	sid, rid := generateKey(config.Version, config.Labels)
 	config.Id = rid
 
+	if err := ps.createConfig(ctx, sid, config); err != nil {
+		tracer.LogError(span, err)
+		return nil, err
+	}
+	return config, nil
+}
+
+func (ps *kvStore) NewConfigVersion(ctx context.Context, config *Config) (*Config, error) {
+	span := tracer.StartSpanFromContext(ctx, "NewConfigVersion")
+	defer span.Finish()
+
+	if err := ValidateVersion(config.Version); err != nil {
+		tracer.LogError(span, err)
+		return nil, err
+	}
+	config.Version = normalizeVersion(config.Version)
+	versions, err := ps.ConfigVersions(ctx, config.Id)
+	if err != nil {
+		tracer.LogError(span, err)
+		return nil, err
+	}
+	if len(versions) == 0 {
+		tracer.LogError(span, ErrConfigNotFound)
+		return nil, ErrConfigNotFound
+	}
+	if hasVersion(versions, config.Version) {
+		tracer.LogError(span, ErrVersionExists)
+		return nil, ErrVersionExists
+	}
+
+	err = ps.createConfig(ctx, constructKey(config.Id, config.Version, config.Labels.String()), config)
+	if errors.Is(err, ErrConflict) {
+		err = ErrVersionExists
+	}
+	if err != nil {
+		tracer.LogError(span, err)
+		return nil, err
+	}
+	return config, nil
+}
+
+func (ps *kvStore) UpdateDraft(ctx context.Context, old *Config, config *Config) (*Config, error) {
+	span := tracer.StartSpanFromContext(ctx, "UpdateDraft")
+	defer span.Finish()
+
+	if !IsDraft(old.Version) {
+		tracer.LogError(span, ErrVersionExists)
+		return nil, ErrVersionExists
+	}
+	if err := validateConfigLabels(config.Labels); err != nil {
+		tracer.LogError(span, err)
+		return nil, err
+	}
+	config.Id, config.Version = old.Id, old.Version
+	config.Audit = old.Audit.updatedAudit(ctx)
+
 	data, err := json.Marshal(config)
 	if err != nil {
 		tracer.LogError(span, err)
 		return nil, err
 	}
 
-	p := &api.KVPair{Key: sid, Value: data}
-	_, err = kv.Put(p, nil)
+	oldKey := constructKey(old.Id, old.Version, old.Labels.String())
+	key := constructKey(config.Id, config.Version, config.Labels.String())
+	ops := []*kvOp{
+		{Verb: kvCheckIndex, Pair: &kvPair{Key: oldKey, Index: old.Index}},
+	}
+	// Only drop index entries that are not written again below, etcd does
+	// not allow touching a key twice in one transaction.
+	kept := map[string]bool{}
+	for _, index := range constructLabelIndexKeys(config) {
+		kept[index] = true
+	}
+	for _, index := range constructLabelIndexKeys(old) {
+		if !kept[index] {
+			ops = append(ops, &kvOp{Verb: kvDelete, Pair: &kvPair{Key: index}})
+		}
+	}
+	if key != oldKey {
+		ops = append(ops,
+			&kvOp{Verb: kvDelete, Pair: &kvPair{Key: oldKey}},
+			&kvOp{Verb: kvCheckIndex, Pair: &kvPair{Key: key}},
+		)
+	}
+	p := &kvPair{Key: key, Value: data}
+	ops = append(ops, &kvOp{Verb: kvSet, Pair: p})
+	for _, index := range constructLabelIndexKeys(config) {
+		ops = append(ops, &kvOp{Verb: kvSet, Pair: &kvPair{Key: index, Value: []byte(key)}})
+	}
+
+	ok, err := ps.kv.Txn(ctx, ops)
 	if err != nil {
 		tracer.LogError(span, err)
 		return nil, err
 	}
+	if !ok {
+		tracer.LogError(span, ErrConflict)
+		return nil, ErrConflict
+	}
 
+	config.Index = p.Index
 	return config, nil
 }
 
-func (ps *Store) PostGroup(ctx context.Context, post *Group) (*Group, error) {
+// createConfig writes config under key together with its label index
+// entries, failing with ErrConflict if key already exists.
+func (ps *kvStore) createConfig(ctx context.Context, key string, config *Config) error {
+	if err := validateConfigLabels(config.Labels); err != nil {
+		return err
+	}
+	config.Audit = createdAudit(ctx)
+	data, err := json.Marshal(config)
+	if err != nil {
+		return err
+	}
+
+	p := &kvPair{Key: key, Value: data}
+	ops := []*kvOp{
+		{Verb: kvCheckIndex, Pair: &kvPair{Key: key}},
+		{Verb: kvSet, Pair: p},
+	}
+	for _, index := range constructLabelIndexKeys(config) {
+		ops = append(ops, &kvOp{Verb: kvSet, Pair: &kvPair{Key: index, Value: []byte(key)}})
+	}
+	ok, err := ps.kv.Txn(ctx, ops)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrConflict
+	}
+
+	config.Index = p.Index
+	return nil
+}
+
+// deleteConfigs removes the config pairs together with their label index
+// entries. It fails with ErrConflict if any of them changed since read, or
+// if a group holding one of the configs changed since the reverse index was
+// read, see groupIndexTxn.
+func (ps *kvStore) deleteConfigs(ctx context.Context, deleted []*Config, cascade bool) ([]*Group, error) {
+	ops := []*kvOp{}
+	deletedKeys := map[string]bool{}
+	for _, config := range deleted {
+		key := constructKey(config.Id, config.Version, config.Labels.String())
+		deletedKeys[key] = true
+		ops = append(ops,
+			&kvOp{Verb: kvCheckIndex, Pair: &kvPair{Key: key, Index: config.Index}},
+			&kvOp{Verb: kvDelete, Pair: &kvPair{Key: key}},
+		)
+		for _, key := range constructLabelIndexKeys(config) {
+			ops = append(ops, &kvOp{Verb: kvDelete, Pair: &kvPair{Key: key}})
+		}
+	}
+	if len(ops) == 0 {
+		return nil, nil
+	}
+
+	index := newGroupIndexTxn(ps.kv)
+	referencing := []string{}
+	detached := []*Group{}
+	ids := map[string]bool{}
+	for _, config := range deleted {
+		ids[config.Id] = true
+	}
+	for id := range ids {
+		groupKeys, err := index.load(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		if len(groupKeys) == 0 {
+			continue
+		}
+		remaining, err := ps.remainingVersions(ctx, id, deletedKeys)
+		if err != nil {
+			return nil, err
+		}
+		for _, key := range sortedKeys(groupKeys) {
+			pair, err := ps.kv.Get(ctx, key)
+			if err != nil {
+				return nil, err
+			}
+			if pair == nil {
+				continue
+			}
+			old, group := &Group{}, &Group{}
+			if err := json.Unmarshal(pair.Value, old); err != nil {
+				return nil, err
+			}
+			if err := json.Unmarshal(pair.Value, group); err != nil {
+				return nil, err
+			}
+			if !detachConfigs(group, deleted, remaining) {
+				continue
+			}
+			if !cascade {
+				referencing = append(referencing, group.Id+"/"+group.Version)
+				continue
+			}
+
+			group.Audit = group.Audit.updatedAudit(ctx)
+			value, err := json.Marshal(group)
+			if err != nil {
+				return nil, err
+			}
+			p := &kvPair{Key: key, Value: value}
+			ops = append(ops,
+				&kvOp{Verb: kvCheckIndex, Pair: &kvPair{Key: key, Index: pair.Index}},
+				&kvOp{Verb: kvSet, Pair: p},
+			)
+			if err := index.update(ctx, key, old, group); err != nil {
+				return nil, err
+			}
+			group.Index = pair.Index
+			detached = append(detached, group)
+		}
+	}
+	if len(referencing) > 0 {
+		return nil, &ReferencedError{Groups: referencing}
+	}
+	indexOps, err := index.ops()
+	if err != nil {
+		return nil, err
+	}
+	ops = append(ops, indexOps...)
+	if len(detached) > 0 && len(ops) > maxTxnOps {
+		// Splitting the ops would drop the guarantee that no group picks
+		// up the config while it is being deleted. The configs themselves
+		// fit, see maxConfigLabels.
+		return nil, fmt.Errorf("%w: detaching %d configs from %d groups takes %d ops, at most %d", ErrCascadeTooLarge, len(deleted), len(detached), len(ops), maxTxnOps)
+	}
+
+	ok, err := ps.kv.Txn(ctx, ops)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, ErrConflict
+	}
+	return detached, nil
+}
+
+// pairConfigs decodes the configs stored in data, with their modify
+// indexes.
+func pairConfigs(data []*kvPair) ([]*Config, error) {
+	configs := make([]*Config, 0, len(data))
+	for _, pair := range data {
+		config := &Config{}
+		if err := json.Unmarshal(pair.Value, config); err != nil {
+			return nil, err
+		}
+		config.Index = pair.Index
+		configs = append(configs, config)
+	}
+	return configs, nil
+}
+
+// remainingVersions lists the versions of config id that keep at least one
+// config once the deleted keys are gone.
+func (ps *kvStore) remainingVersions(ctx context.Context, id string, deleted map[string]bool) ([]string, error) {
+	data, err := ps.kv.List(ctx, constructKey2(id)+"/")
+	if err != nil {
+		return nil, err
+	}
+	versions := []string{}
+	for _, pair := range data {
+		if deleted[pair.Key] {
+			continue
+		}
+		config := &Config{}
+		if err := json.Unmarshal(pair.Value, config); err != nil {
+			return nil, err
+		}
+		versions = append(versions, config.Version)
+	}
+	return versions, nil
+}
+
+func (ps *kvStore) FindConfigsByLabels(ctx context.Context, labels Labels) ([]*Config, error) {
+	span := tracer.StartSpanFromContext(ctx, "FindConfigsByLabels")
+	defer span.Finish()
+
+	keys, err := ps.labelIndexKeys(ctx, labels)
+	if err != nil {
+		tracer.LogError(span, err)
+		return nil, err
+	}
+	data, err := ps.kv.GetMany(ctx, keys)
+	if err != nil {
+		tracer.LogError(span, err)
+		return nil, err
+	}
+
+	configs := []*Config{}
+	for _, pair := range data {
+		config := &Config{}
+		err = json.Unmarshal(pair.Value, config)
+		if err != nil {
+			tracer.LogError(span, err)
+			return nil, err
+		}
+		config.Index = pair.Index
+		configs = append(configs, config)
+	}
+	return configs, nil
+}
+
+// labelIndexKeys returns the sorted keys of the configs carrying all of
+// labels. It intersects the config keys of every label, the index value is
+// the key of the config.
+func (ps *kvStore) labelIndexKeys(ctx context.Context, labels Labels) ([]string, error) {
+	var keys map[string]bool
+	for label, value := range labels {
+		data, err := ps.kv.List(ctx, fmt.Sprintf(labelIndexPrefix, label, value))
+		if err != nil {
+			return nil, err
+		}
+		found := map[string]bool{}
+		for _, pair := range data {
+			if keys == nil || keys[string(pair.Value)] {
+				found[string(pair.Value)] = true
+			}
+		}
+		keys = found
+	}
+	return sortedKeys(keys), nil
+}
+
+func (ps *kvStore) PostGroup(ctx context.Context, post *Group) (*Group, error) {
 	span := tracer.StartSpanFromContext(ctx, "PostGroup")
 	defer span.Finish()
 
-	kv := ps.cli.KV()
+	if err := ValidateVersion(post.Version); err != nil {
+		tracer.LogError(span, err)
+		return nil, err
+	}
+	post.Version = normalizeVersion(post.Version)
 
 	sid, rid := generateGroupKey(post.Version)
 	post.Id = rid
+	post.Audit = createdAudit(ctx)
 
 	data, err := json.Marshal(post)
 	if err != nil {
@@ -360,61 +1066,173 @@ func (ps *Store) PostGroup(ctx context.Context, post *Group) (*Group, error) {
 		return nil, err
 	}
 
-	p := &api.KVPair{Key: sid, Value: data}
-	_, err = kv.Put(p, nil)
+	p := &kvPair{Key: sid, Value: data}
+	ops := []*kvOp{
+		{Verb: kvCheckIndex, Pair: &kvPair{Key: sid}},
+		{Verb: kvSet, Pair: p},
+	}
+	indexOps, err := ps.groupIndexOps(ctx, sid, nil, post)
+	if err != nil {
+		tracer.LogError(span, err)
+		return nil, err
+	}
+	checks, err := ps.memberChecks(ctx, nil, post)
 	if err != nil {
 		tracer.LogError(span, err)
 		return nil, err
 	}
+	ops = append(ops, checks...)
+	ok, err := ps.kv.Txn(ctx, append(ops, indexOps...))
+	if err != nil {
+		tracer.LogError(span, err)
+		return nil, err
+	}
+	if !ok {
+		tracer.LogError(span, ErrConflict)
+		return nil, ErrConflict
+	}
 
+	post.Index = p.Index
 	return post, nil
 }
 
-/*
-	func (ps *Store) GetGroupsByLabels(ctx context.Context, id string, version string, labels string) ([]*Group, error) {
-		span := tracer.StartSpanFromContext(ctx, "GetGroupsByLabel")
-		defer span.Finish()
-		kv := ps.cli.KV()
+func (ps *kvStore) GetGroupsByLabels(ctx context.Context, id string, version string, labels string) ([]*Group, error) {
+	span := tracer.StartSpanFromContext(ctx, "GetGroupsByLabel")
+	defer span.Finish()
+
+	parsed, err := ParseLabels(labels)
+	if err != nil {
+		tracer.LogError(span, err)
+		return nil, err
+	}
 
-		data, _, err := kv.List(constructGroupKey(id, version,labels), nil)
+	data, err := ps.kv.List(ctx, constructGroupKey(id, version))
+	if err != nil {
+		tracer.LogError(span, err)
+		return nil, err
+	}
+
+	groups := []*Group{}
+	for _, pair := range data {
+		group := &Group{}
+		err = json.Unmarshal(pair.Value, group)
 		if err != nil {
 			tracer.LogError(span, err)
 			return nil, err
 		}
+		if group.Labels.String() != parsed.String() {
+			continue
+		}
+		group.Index = pair.Index
+		groups = append(groups, group)
+	}
+	return groups, nil
+}
 
-		posts := []*Group{}
+func (ps *kvStore) GetGroupsBySelector(ctx context.Context, id string, version string, selector Selector) ([]*Group, error) {
+	span := tracer.StartSpanFromContext(ctx, "GetGroupsBySelector")
+	defer span.Finish()
 
-		for _, pair := range data {
-			post := &Group{}
-			err = json.Unmarshal(pair.Value, post)
-			if err != nil {
-				tracer.LogError(span, err)
-				return nil, err
-			}
-			posts = append(posts, post)
-		}
+	prefix := allGroups
+	if id != "" && version != "" {
+		prefix = constructGroupKey(id, version)
+	} else if id != "" {
+		prefix = constructGroupKey2(id)
+	}
+	data, err := ps.kv.List(ctx, prefix)
+	if err != nil {
+		tracer.LogError(span, err)
+		return nil, err
+	}
 
+	groups := []*Group{}
+	for _, pair := range data {
+		group := &Group{}
+		err = json.Unmarshal(pair.Value, group)
 		if err != nil {
 			tracer.LogError(span, err)
 			return nil, err
 		}
+		if !selector.Matches(group.Labels) {
+			continue
+		}
+		group.Index = pair.Index
+		groups = append(groups, group)
+	}
+	return groups, nil
+}
 
-		return posts, nil
+func (ps *kvStore) DeleteGroupByLabels(ctx context.Context, id string, version string, labels string) (map[string]string, error) {
+	span := tracer.StartSpanFromContext(ctx, "DeleteGroupByLabels")
+	defer span.Finish()
+
+	groups, err := ps.GetGroupsByLabels(ctx, id, version, labels)
+	if err != nil {
+		tracer.LogError(span, err)
+		return nil, err
 	}
-*/
-func (ps *Store) GetConfigsByLabels(ctx context.Context, id string, version string, labels string) ([]*Config, error) {
+
+	if len(groups) == 0 {
+		tracer.LogError(span, ErrGroupNotFound)
+		return nil, ErrGroupNotFound
+	}
+	err = ps.deleteGroups(ctx, groups)
+	if err != nil {
+		tracer.LogError(span, err)
+		return nil, err
+	}
+
+	return map[string]string{"Deleted": id}, nil
+}
+func (ps *kvStore) GetConfigsByLabels(ctx context.Context, id string, version string, labels string) ([]*Config, error) {
 	span := tracer.StartSpanFromContext(ctx, "GetConfigsByLabel")
 	defer span.Finish()
-	kv := ps.cli.KV()
 
-	data, _, err := kv.List(constructKey(id, version, labels), nil)
+	parsed, err := ParseLabels(labels)
 	if err != nil {
 		tracer.LogError(span, err)
 		return nil, err
 	}
 
-	configs := []*Config{}
+	// The labels are the last segment of the key, listing by it as a
+	// prefix would also pick up env=prod for env=pr.
+	pair, err := ps.kv.Get(ctx, constructKey(id, version, parsed.String()))
+	if err != nil {
+		tracer.LogError(span, err)
+		return nil, err
+	}
+	data := []*kvPair{}
+	if pair != nil {
+		data = append(data, pair)
+	}
+	configs, err := pairConfigs(data)
+	if err != nil {
+		tracer.LogError(span, err)
+		return nil, err
+	}
+	return configs, nil
+}
+
+func (ps *kvStore) GetConfigsBySelector(ctx context.Context, id string, version string, selector Selector) ([]*Config, error) {
+	span := tracer.StartSpanFromContext(ctx, "GetConfigsBySelector")
+	defer span.Finish()
+
+	prefix := all
+	if id != "" && version != "" {
+		prefix = constructKey(id, version, "")
+	} else if id != "" {
+		prefix = constructKey2(id) + "/"
+	}
+	data, err := ps.kv.List(ctx, prefix)
+	if err != nil {
+		tracer.LogError(span, err)
+		return nil, err
+	}
+	if id != "" && version != "" {
+		data = versionPairs(data, id, version)
+	}
 
+	configs := []*Config{}
 	for _, pair := range data {
 		config := &Config{}
 		err = json.Unmarshal(pair.Value, config)
@@ -422,57 +1240,112 @@ func (ps *Store) GetConfigsByLabels(ctx context.Context, id string, version stri
 			tracer.LogError(span, err)
 			return nil, err
 		}
+		if !selector.Matches(config.Labels) {
+			continue
+		}
+		config.Index = pair.Index
 		configs = append(configs, config)
 	}
+	return configs, nil
+}
+
+func (ps *kvStore) NewGroupVersion(ctx context.Context, post *Group) (*Group, error) {
+	span := tracer.StartSpanFromContext(ctx, "NewGroupVersion")
+	defer span.Finish()
 
+	if err := ValidateVersion(post.Version); err != nil {
+		tracer.LogError(span, err)
+		return nil, err
+	}
+	post.Version = normalizeVersion(post.Version)
+	versions, err := ps.GroupVersions(ctx, post.Id)
 	if err != nil {
 		tracer.LogError(span, err)
 		return nil, err
 	}
+	if len(versions) == 0 {
+		tracer.LogError(span, ErrGroupNotFound)
+		return nil, ErrGroupNotFound
+	}
+	if hasVersion(versions, post.Version) {
+		tracer.LogError(span, ErrVersionExists)
+		return nil, ErrVersionExists
+	}
 
-	return configs, nil
+	post.Index = 0
+	return ps.SaveGroup(ctx, post)
 }
 
-func (ps *Store) SaveRequestId(ctx context.Context) string {
-	span := tracer.StartSpanFromContext(ctx, "SaveRequestId")
+func (ps *kvStore) ConfigVersions(ctx context.Context, id string) ([]string, error) {
+	span := tracer.StartSpanFromContext(ctx, "ConfigVersions")
 	defer span.Finish()
-	kv := ps.cli.KV()
-
-	reqId := generateRequestId(ctx)
 
-	i := &api.KVPair{Key: reqId, Value: nil}
-
-	_, err := kv.Put(i, nil)
+	data, err := ps.kv.List(ctx, constructKey2(id)+"/")
 	if err != nil {
 		tracer.LogError(span, err)
-		return "error"
+		return nil, err
 	}
-	return reqId
 
+	seen := map[string]bool{}
+	versions := []string{}
+	for _, pair := range data {
+		config := &Config{}
+		err = json.Unmarshal(pair.Value, config)
+		if err != nil {
+			tracer.LogError(span, err)
+			return nil, err
+		}
+		if !seen[config.Version] {
+			seen[config.Version] = true
+			versions = append(versions, config.Version)
+		}
+	}
+	sortVersions(versions)
+	return versions, nil
 }
 
-func (ps *Store) FindRequestId(ctx context.Context, requestId string) bool {
-	span := tracer.StartSpanFromContext(ctx, "GetRequestId")
+func (ps *kvStore) GroupVersions(ctx context.Context, id string) ([]string, error) {
+	span := tracer.StartSpanFromContext(ctx, "GroupVersions")
 	defer span.Finish()
 
-	kv := ps.cli.KV()
-
-	key, _, err := kv.Get(requestId, nil)
-
-	fmt.Println(key)
-
-	if err != nil || key == nil {
+	data, err := ps.kv.List(ctx, constructGroupKey2(id))
+	if err != nil {
 		tracer.LogError(span, err)
-		return false
+		return nil, err
 	}
 
-	return true
+	versions := []string{}
+	for _, pair := range data {
+		group := &Group{}
+		err = json.Unmarshal(pair.Value, group)
+		if err != nil {
+			tracer.LogError(span, err)
+			return nil, err
+		}
+		versions = append(versions, group.Version)
+	}
+	sortVersions(versions)
+	return versions, nil
+}
 
+func (ps *kvStore) ResolveConfigVersion(ctx context.Context, id string, version string) (string, error) {
+	if !IsVersionRange(version) {
+		return normalizeVersion(version), nil
+	}
+	versions, err := ps.ConfigVersions(ctx, id)
+	if err != nil {
+		return "", err
+	}
+	return resolveVersion(versions, version)
 }
-func generateRequestId(ctx context.Context) string {
-	span := tracer.StartSpanFromContext(ctx, "Generate")
-	defer span.Finish()
-	rid := uuid.New().String()
 
-	return rid
+func (ps *kvStore) ResolveGroupVersion(ctx context.Context, id string, version string) (string, error) {
+	if !IsVersionRange(version) {
+		return normalizeVersion(version), nil
+	}
+	versions, err := ps.GroupVersions(ctx, id)
+	if err != nil {
+		return "", err
+	}
+	return resolveVersion(versions, version)
 }
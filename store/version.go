@@ -0,0 +1,172 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"github.com/Masterminds/semver/v3"
+	"sort"
+	"strings"
+)
+
+// LatestVersion resolves to the highest stable version.
+const LatestVersion = "latest"
+
+var (
+	// ErrInvalidVersion is returned when creating a config or group whose
+	// version is not a semantic version.
+	ErrInvalidVersion = errors.New("version is not a semantic version")
+
+	// ErrVersionNotFound is returned when no stored version satisfies a
+	// version range.
+	ErrVersionNotFound = errors.New("no version matches")
+)
+
+// ValidateVersion checks that version is a semantic version. A leading v
+// and missing minor or patch numbers are allowed, so "v1" is 1.0.0, and
+// that is how it is stored, see normalizeVersion.
+func ValidateVersion(version string) error {
+	if _, err := semver.NewVersion(version); err != nil {
+		return fmt.Errorf("%w: %q", ErrInvalidVersion, version)
+	}
+	return nil
+}
+
+// normalizeVersion is the form versions are stored and looked up in, like
+// 1.0.0 for v1 or 1.0, so every spelling of a version finds the same key.
+// Ranges and anything else that is not a version are returned as is.
+func normalizeVersion(version string) string {
+	v, err := semver.NewVersion(version)
+	if err != nil {
+		return version
+	}
+	return v.String()
+}
+
+// IsVersionRange reports whether version has to be resolved against the
+// stored versions: "latest" or a range like "^1.2", "~1.4.0" or ">=2".
+func IsVersionRange(version string) bool {
+	if version == LatestVersion {
+		return true
+	}
+	if _, err := semver.NewVersion(version); err == nil {
+		return false
+	}
+	_, err := semver.NewConstraint(version)
+	return err == nil
+}
+
+// draftTag is the prerelease identifier that marks a version as a draft.
+const draftTag = "draft"
+
+// IsDraft reports whether version is a draft, a prerelease tagged draft
+// like 1.2.0-draft or 1.2.0-draft.3. Drafts are the only versions that can
+// be changed after they were published, other prereleases like 2.0.0-rc.1
+// are as immutable as releases.
+func IsDraft(version string) bool {
+	v, err := semver.NewVersion(version)
+	if err != nil {
+		return false
+	}
+	tag, _, _ := strings.Cut(v.Prerelease(), ".")
+	return tag == draftTag
+}
+
+// NextPatchVersion returns the version after the highest of versions with
+// the same major and minor version as source, so patching 1.2.3 while
+// 1.2.5 and 2.0.0 exist gives 1.2.6.
+func NextPatchVersion(versions []string, source string) (string, error) {
+	next, err := semver.NewVersion(source)
+	if err != nil {
+		return "", fmt.Errorf("%w: %q", ErrInvalidVersion, source)
+	}
+	for _, version := range versions {
+		v, err := semver.NewVersion(version)
+		if err == nil && v.Major() == next.Major() && v.Minor() == next.Minor() && v.GreaterThan(next) {
+			next = v
+		}
+	}
+	bumped := next.IncPatch()
+	return bumped.String(), nil
+}
+
+// hasVersion reports whether versions contains version, comparing semantic
+// versions by value so that v1 and 1.0.0 are the same.
+func hasVersion(versions []string, version string) bool {
+	want, err := semver.NewVersion(version)
+	for _, v := range versions {
+		if v == version {
+			return true
+		}
+		if got, gotErr := semver.NewVersion(v); err == nil && gotErr == nil && got.Equal(want) {
+			return true
+		}
+	}
+	return false
+}
+
+// sortVersions orders versions by semantic version. Versions stored before
+// validation that do not parse come first, in string order.
+func sortVersions(versions []string) {
+	sort.SliceStable(versions, func(i, j int) bool {
+		return compareVersions(versions[i], versions[j]) < 0
+	})
+}
+
+// compareVersions returns -1, 0 or 1 as a is lower than, the same as or
+// higher than b, in the order of sortVersions.
+func compareVersions(a string, b string) int {
+	va, errA := semver.NewVersion(a)
+	vb, errB := semver.NewVersion(b)
+	switch {
+	case errA != nil && errB != nil:
+		return strings.Compare(a, b)
+	case errA != nil:
+		return -1
+	case errB != nil:
+		return 1
+	}
+	return va.Compare(vb)
+}
+
+// resolveVersion picks the highest of versions matching query. latest
+// prefers stable versions and only falls back to a prerelease when there
+// is nothing else.
+func resolveVersion(versions []string, query string) (string, error) {
+	var constraint *semver.Constraints
+	if query != LatestVersion {
+		c, err := semver.NewConstraint(query)
+		if err != nil {
+			return "", err
+		}
+		constraint = c
+	}
+
+	var best, bestPre *semver.Version
+	var found, foundPre string
+	for _, version := range versions {
+		v, err := semver.NewVersion(version)
+		if err != nil {
+			continue
+		}
+		if constraint != nil && !constraint.Check(v) {
+			continue
+		}
+		if v.Prerelease() != "" {
+			if bestPre == nil || v.GreaterThan(bestPre) {
+				bestPre, foundPre = v, version
+			}
+			continue
+		}
+		if best == nil || v.GreaterThan(best) {
+			best, found = v, version
+		}
+	}
+
+	switch {
+	case best != nil:
+		return found, nil
+	case bestPre != nil:
+		return foundPre, nil
+	}
+	return "", fmt.Errorf("%w %q", ErrVersionNotFound, query)
+}
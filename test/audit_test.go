@@ -0,0 +1,61 @@
+package test
+
+import (
+	"context"
+	"example.com/mod/store"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestAuditStamps(t *testing.T) {
+	st, err := store.NewBolt(filepath.Join(t.TempDir(), "alati.db"))
+	if err != nil {
+		t.Fatalf("NewBolt failed: %v", err)
+	}
+	defer st.Close()
+
+	ctx := store.WithChange(context.Background(), store.Change{Author: "ana", Description: "first draft"})
+	draft, err := st.Config(ctx, &store.Config{
+		Version: "1.0.0-draft.1",
+		Entries: map[string]string{"pool": "10"},
+		Audit:   store.Audit{CreatedBy: "mallory"},
+	})
+	if err != nil {
+		t.Fatalf("Config failed: %v", err)
+	}
+	if draft.CreatedAt == nil || draft.CreatedBy != "ana" || draft.UpdatedBy != "ana" || draft.ChangeDescription != "first draft" {
+		t.Fatalf("created audit = %+v", draft.Audit)
+	}
+
+	since := time.Now()
+	ctx = store.WithChange(context.Background(), store.Change{Author: "bob", Description: "raise pool"})
+	updated, err := st.UpdateDraft(ctx, draft, &store.Config{Entries: map[string]string{"pool": "20"}})
+	if err != nil {
+		t.Fatalf("UpdateDraft failed: %v", err)
+	}
+	if !updated.CreatedAt.Equal(*draft.CreatedAt) || updated.CreatedBy != "ana" {
+		t.Errorf("update changed creation: %+v", updated.Audit)
+	}
+	if updated.UpdatedBy != "bob" || updated.ChangeDescription != "raise pool" || updated.UpdatedAt.Before(since) {
+		t.Errorf("updated audit = %+v", updated.Audit)
+	}
+
+	if _, err := st.Config(ctx, &store.Config{Version: "2.0.0"}); err != nil {
+		t.Fatalf("Config failed: %v", err)
+	}
+	configs, _, err := st.ListConfigs(ctx, store.PageOptions{Limit: 10, UpdatedSince: since})
+	if err != nil {
+		t.Fatalf("ListConfigs failed: %v", err)
+	}
+	if len(configs) != 2 {
+		t.Errorf("updated since returned %d configs, want 2", len(configs))
+	}
+	configs, _, err = st.ListConfigs(ctx, store.PageOptions{Limit: 10, UpdatedSince: time.Now().Add(time.Hour)})
+	if err != nil {
+		t.Fatalf("ListConfigs failed: %v", err)
+	}
+	if len(configs) != 0 {
+		t.Errorf("updated since the future returned %d configs", len(configs))
+	}
+}
@@ -0,0 +1,103 @@
+package test
+
+import (
+	"context"
+	"example.com/mod/store"
+	"go.etcd.io/etcd/server/v3/embed"
+	"net"
+	"net/url"
+	"testing"
+	"time"
+)
+
+// startEtcd runs a single member etcd server in a temp dir and returns its
+// client endpoint.
+func startEtcd(t *testing.T) string {
+	t.Helper()
+
+	cfg := embed.NewConfig()
+	cfg.Dir = t.TempDir()
+	cfg.LogLevel = "error"
+	clientURL := url.URL{Scheme: "http", Host: freeAddr(t)}
+	peerURL := url.URL{Scheme: "http", Host: freeAddr(t)}
+	cfg.ListenClientUrls, cfg.AdvertiseClientUrls = []url.URL{clientURL}, []url.URL{clientURL}
+	cfg.ListenPeerUrls, cfg.AdvertisePeerUrls = []url.URL{peerURL}, []url.URL{peerURL}
+	cfg.InitialCluster = cfg.InitialClusterFromName(cfg.Name)
+
+	e, err := embed.StartEtcd(cfg)
+	if err != nil {
+		t.Fatalf("starting etcd failed: %v", err)
+	}
+	t.Cleanup(e.Close)
+
+	select {
+	case <-e.Server.ReadyNotify():
+	case <-time.After(10 * time.Second):
+		t.Fatalf("etcd did not become ready")
+	}
+	return clientURL.Host
+}
+
+func freeAddr(t *testing.T) string {
+	t.Helper()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("no free port: %v", err)
+	}
+	defer l.Close()
+	return l.Addr().String()
+}
+
+func TestEtcdStore(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	st, err := store.NewEtcd(startEtcd(t))
+	if err != nil {
+		t.Fatalf("NewEtcd failed: %v", err)
+	}
+	defer st.Close()
+
+	group, err := st.PostGroup(ctx, &store.Group{Version: "v1"})
+	if err != nil {
+		t.Fatalf("PostGroup failed: %v", err)
+	}
+	changes, err := st.WatchGroups(ctx, group.Id)
+	if err != nil {
+		t.Fatalf("WatchGroups failed: %v", err)
+	}
+
+	config, err := st.Config(ctx, &store.Config{Entries: map[string]string{"a": "1"}, Version: "v1"})
+	if err != nil {
+		t.Fatalf("Config failed: %v", err)
+	}
+	group.Configs = append(group.Configs, *config)
+	if _, err := st.SaveGroup(ctx, group); err != nil {
+		t.Fatalf("SaveGroup failed: %v", err)
+	}
+
+	select {
+	case <-changes:
+	case <-ctx.Done():
+		t.Fatalf("no watch notification for group %s", group.Id)
+	}
+
+	configs, next, err := st.ListConfigs(ctx, store.PageOptions{Limit: 10, Sort: store.SortByCreated})
+	if err != nil || len(configs) != 1 || configs[0].Id != config.Id || next != "" {
+		t.Errorf("ListConfigs returned %v, %q, %v", configs, next, err)
+	}
+
+	got, err := st.GetOneGroup(ctx, group.Id, "v1")
+	if err != nil || len(got.Configs) != 1 || got.Configs[0].Id != config.Id {
+		t.Errorf("GetOneGroup returned %v, %v", got, err)
+	}
+
+	if _, err := st.DeleteGroup(ctx, group.Id, "v1"); err != nil {
+		t.Fatalf("DeleteGroup failed: %v", err)
+	}
+	groups, err := st.GetAllGroups(ctx)
+	if err != nil || len(groups) != 0 {
+		t.Errorf("GetAllGroups returned %d groups, %v", len(groups), err)
+	}
+}
@@ -0,0 +1,197 @@
+package test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"example.com/mod/store"
+	"fmt"
+	"testing"
+)
+
+func TestLabelsLegacyString(t *testing.T) {
+	config := &store.Config{}
+	if err := json.Unmarshal([]byte(`{"labels":"region=eu, env=prod"}`), config); err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+	if got := config.Labels.String(); got != "env=prod,region=eu" {
+		t.Errorf("canonical labels = %q", got)
+	}
+
+	if err := json.Unmarshal([]byte(`{"labels":{"env":"a/b"}}`), config); !errors.Is(err, store.ErrInvalidLabels) {
+		t.Errorf("expected label value with / to be rejected, got %v", err)
+	}
+}
+
+func TestSelector(t *testing.T) {
+	labels := store.Labels{"env": "prod", "region": "eu"}
+	cases := map[string]bool{
+		"":                              true,
+		"env=prod":                      true,
+		"env==prod,region":              true,
+		"env!=prod":                     false,
+		"region in (eu,us)":             true,
+		"region notin (eu, us)":         false,
+		"!canary":                       true,
+		"canary":                        false,
+		"env=prod,region in (us),!beta": false,
+	}
+	for selector, want := range cases {
+		parsed, err := store.ParseSelector(selector)
+		if err != nil {
+			t.Errorf("ParseSelector(%q) failed: %v", selector, err)
+			continue
+		}
+		if got := parsed.Matches(labels); got != want {
+			t.Errorf("%q matches = %v, want %v", selector, got, want)
+		}
+	}
+
+	if _, err := store.ParseSelector("region in (eu"); err == nil {
+		t.Errorf("expected unbalanced selector to fail")
+	}
+}
+
+func TestGetConfigsBySelector(t *testing.T) {
+	ctx := context.Background()
+	st := store.NewMemory()
+
+	for _, labels := range []store.Labels{{"env": "prod"}, {"env": "dev", "canary": ""}, nil} {
+		if _, err := st.Config(ctx, &store.Config{Version: "v1", Labels: labels}); err != nil {
+			t.Fatalf("Config failed: %v", err)
+		}
+	}
+
+	selector, _ := store.ParseSelector("env in (prod,dev),!canary")
+	configs, err := st.GetConfigsBySelector(ctx, "", "", selector)
+	if err != nil || len(configs) != 1 || configs[0].Labels["env"] != "prod" {
+		t.Errorf("GetConfigsBySelector returned %v, %v", configs, err)
+	}
+}
+
+func TestGetConfigsByLabelsIsExact(t *testing.T) {
+	ctx := context.Background()
+	st := store.NewMemory()
+
+	config, err := st.Config(ctx, &store.Config{Version: "1.0.0", Labels: store.Labels{"env": "prod", "region": "eu"}})
+	if err != nil {
+		t.Fatalf("Config failed: %v", err)
+	}
+
+	// Both are prefixes of the stored env=prod,region=eu.
+	for _, labels := range []string{"env=pr", "env=prod"} {
+		configs, err := st.GetConfigsByLabels(ctx, config.Id, config.Version, labels)
+		if err != nil || len(configs) != 0 {
+			t.Errorf("GetConfigsByLabels(%q) returned %v, %v", labels, configs, err)
+		}
+		if _, _, err := st.DeleteByLabel(ctx, config.Id, config.Version, labels, false); !errors.Is(err, store.ErrConfigNotFound) {
+			t.Errorf("DeleteByLabel(%q) returned %v, want ErrConfigNotFound", labels, err)
+		}
+	}
+
+	configs, err := st.GetConfigsByLabels(ctx, config.Id, config.Version, "region=eu,env=prod")
+	if err != nil || len(configs) != 1 {
+		t.Errorf("exact GetConfigsByLabels returned %v, %v", configs, err)
+	}
+}
+
+func TestConfigLabelLimit(t *testing.T) {
+	ctx := context.Background()
+	st := store.NewMemory()
+
+	labels := func(n int) store.Labels {
+		labels := store.Labels{}
+		for i := 0; i < n; i++ {
+			labels[fmt.Sprintf("l%d", i)] = "v"
+		}
+		return labels
+	}
+
+	if _, err := st.Config(ctx, &store.Config{Version: "1.0.0", Labels: labels(17)}); !errors.Is(err, store.ErrInvalidLabels) {
+		t.Errorf("expected ErrInvalidLabels for 17 labels, got %v", err)
+	}
+	draft, err := st.Config(ctx, &store.Config{Version: "1.0.0-draft", Labels: labels(16)})
+	if err != nil {
+		t.Fatalf("Config with 16 labels failed: %v", err)
+	}
+	if _, err := st.UpdateDraft(ctx, draft, &store.Config{Labels: labels(17)}); !errors.Is(err, store.ErrInvalidLabels) {
+		t.Errorf("expected ErrInvalidLabels updating to 17 labels, got %v", err)
+	}
+
+	if _, err := st.PostGroup(ctx, &store.Group{Version: "1.0.0", Configs: []store.Config{*draft}}); err != nil {
+		t.Fatalf("PostGroup failed: %v", err)
+	}
+	if _, groups, err := st.Delete(ctx, draft.Id, draft.Version, true); err != nil || len(groups) != 1 {
+		t.Errorf("cascading delete of a config with 16 labels returned %v, %v", groups, err)
+	}
+}
+
+func TestFindConfigsByLabels(t *testing.T) {
+	ctx := context.Background()
+	st := store.NewMemory()
+
+	payments, err := st.Config(ctx, &store.Config{Version: "v1", Labels: store.Labels{"team": "payments", "env": "prod"}})
+	if err != nil {
+		t.Fatalf("Config failed: %v", err)
+	}
+	if _, err := st.Config(ctx, &store.Config{Version: "v2", Labels: store.Labels{"team": "payments", "env": "dev"}}); err != nil {
+		t.Fatalf("Config failed: %v", err)
+	}
+	if _, err := st.Config(ctx, &store.Config{Version: "v1", Labels: store.Labels{"team": "search"}}); err != nil {
+		t.Fatalf("Config failed: %v", err)
+	}
+
+	configs, err := st.FindConfigsByLabels(ctx, store.Labels{"team": "payments"})
+	if err != nil || len(configs) != 2 {
+		t.Errorf("FindConfigsByLabels returned %d configs, %v", len(configs), err)
+	}
+	configs, err = st.FindConfigsByLabels(ctx, store.Labels{"team": "payments", "env": "prod"})
+	if err != nil || len(configs) != 1 || configs[0].Id != payments.Id {
+		t.Errorf("FindConfigsByLabels returned %v, %v", configs, err)
+	}
+
+	if _, _, err := st.Delete(ctx, payments.Id, "v1", false); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	configs, err = st.FindConfigsByLabels(ctx, store.Labels{"env": "prod"})
+	if err != nil || len(configs) != 0 {
+		t.Errorf("index still has deleted config: %v, %v", configs, err)
+	}
+}
+
+func TestGroupLabels(t *testing.T) {
+	ctx := context.Background()
+	st := store.NewMemory()
+
+	prod, err := st.PostGroup(ctx, &store.Group{Version: "v1", Labels: store.Labels{"env": "prod", "team": "payments"}})
+	if err != nil {
+		t.Fatalf("PostGroup failed: %v", err)
+	}
+	if _, err := st.PostGroup(ctx, &store.Group{Version: "v1", Labels: store.Labels{"env": "dev"}}); err != nil {
+		t.Fatalf("PostGroup failed: %v", err)
+	}
+
+	groups, err := st.GetGroupsByLabels(ctx, prod.Id, "v1", "team=payments,env=prod")
+	if err != nil || len(groups) != 1 {
+		t.Errorf("GetGroupsByLabels returned %v, %v", groups, err)
+	}
+
+	selector, _ := store.ParseSelector("env!=prod")
+	groups, err = st.GetGroupsBySelector(ctx, "", "", selector)
+	if err != nil || len(groups) != 1 || groups[0].Labels["env"] != "dev" {
+		t.Errorf("GetGroupsBySelector returned %v, %v", groups, err)
+	}
+
+	if _, err := st.DeleteGroupByLabels(ctx, prod.Id, "v1", "env=dev"); !errors.Is(err, store.ErrGroupNotFound) {
+		t.Fatalf("expected ErrGroupNotFound, got %v", err)
+	}
+	if _, err := st.GetOneGroup(ctx, prod.Id, "v1"); err != nil {
+		t.Errorf("group with other labels was deleted")
+	}
+	if _, err := st.DeleteGroupByLabels(ctx, prod.Id, "v1", "env=prod,team=payments"); err != nil {
+		t.Fatalf("DeleteGroupByLabels failed: %v", err)
+	}
+	if _, err := st.GetOneGroup(ctx, prod.Id, "v1"); err == nil {
+		t.Errorf("expected group to be deleted")
+	}
+}
@@ -0,0 +1,91 @@
+package test
+
+import (
+	"context"
+	"errors"
+	"example.com/mod/store"
+	"path/filepath"
+	"testing"
+)
+
+func TestListConfigsPages(t *testing.T) {
+	ctx := context.Background()
+	st, err := store.NewBolt(filepath.Join(t.TempDir(), "alati.db"))
+	if err != nil {
+		t.Fatalf("NewBolt failed: %v", err)
+	}
+	defer st.Close()
+
+	created := []string{}
+	for _, version := range []string{"2.0.0", "1.0.0", "1.10.0", "1.2.0", "3.0.0"} {
+		config, err := st.Config(ctx, &store.Config{Version: version, Labels: store.Labels{"env": "prod"}})
+		if err != nil {
+			t.Fatalf("Config failed: %v", err)
+		}
+		created = append(created, config.Version)
+	}
+
+	walk := func(opts store.PageOptions) []string {
+		versions := []string{}
+		for {
+			configs, next, err := st.ListConfigs(ctx, opts)
+			if err != nil {
+				t.Fatalf("ListConfigs failed: %v", err)
+			}
+			if len(configs) > opts.Limit {
+				t.Fatalf("page of %d configs, limit %d", len(configs), opts.Limit)
+			}
+			for _, config := range configs {
+				versions = append(versions, config.Version)
+			}
+			if next == "" {
+				return versions
+			}
+			opts.Cursor = next
+		}
+	}
+
+	if got := walk(store.PageOptions{Limit: 2, Sort: store.SortByCreated}); !equal(got, created) {
+		t.Errorf("by created = %v, want %v", got, created)
+	}
+	reversed := []string{}
+	for i := len(created) - 1; i >= 0; i-- {
+		reversed = append(reversed, created[i])
+	}
+	if got := walk(store.PageOptions{Limit: 3, Sort: "-" + store.SortByCreated}); !equal(got, reversed) {
+		t.Errorf("by created descending = %v, want %v", got, reversed)
+	}
+	want := []string{"3.0.0", "2.0.0", "1.10.0", "1.2.0", "1.0.0"}
+	if got := walk(store.PageOptions{Limit: 2, Sort: "-" + store.SortByVersion}); !equal(got, want) {
+		t.Errorf("by version descending = %v, want %v", got, want)
+	}
+	if got := walk(store.PageOptions{Limit: 3, Labels: store.Labels{"env": "prod"}}); len(got) != 5 {
+		t.Errorf("by label returned %v", got)
+	}
+	if got := walk(store.PageOptions{Limit: 1, Selector: store.Selector{}}); len(got) != 5 {
+		t.Errorf("by id returned %v", got)
+	}
+
+	_, next, err := st.ListConfigs(ctx, store.PageOptions{Limit: 2, Sort: store.SortByVersion})
+	if err != nil || next == "" {
+		t.Fatalf("ListConfigs returned cursor %q, %v", next, err)
+	}
+	if _, _, err := st.ListConfigs(ctx, store.PageOptions{Limit: 2, Cursor: next}); !errors.Is(err, store.ErrInvalidCursor) {
+		t.Errorf("expected cursor of another order to be rejected, got %v", err)
+	}
+	if _, _, err := st.ListConfigs(ctx, store.PageOptions{Limit: 2, Sort: "size"}); !errors.Is(err, store.ErrInvalidSort) {
+		t.Errorf("expected unknown sort to be rejected, got %v", err)
+	}
+}
+
+func equal(a []string, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
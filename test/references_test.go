@@ -0,0 +1,123 @@
+package test
+
+import (
+	"context"
+	"errors"
+	"example.com/mod/store"
+	"testing"
+)
+
+func TestDeleteReferencedConfig(t *testing.T) {
+	ctx := context.Background()
+	st := store.NewMemory()
+
+	config, err := st.Config(ctx, &store.Config{Version: "1.0.0", Entries: map[string]string{"a": "1"}})
+	if err != nil {
+		t.Fatalf("Config failed: %v", err)
+	}
+	group, err := st.PostGroup(ctx, &store.Group{Version: "1.0.0", Configs: []store.Config{*config}})
+	if err != nil {
+		t.Fatalf("PostGroup failed: %v", err)
+	}
+
+	_, _, err = st.Delete(ctx, config.Id, "1.0.0", false)
+	var referenced *store.ReferencedError
+	if !errors.As(err, &referenced) || !errors.Is(err, store.ErrReferenced) {
+		t.Fatalf("expected ReferencedError, got %v", err)
+	}
+	if len(referenced.Groups) != 1 || referenced.Groups[0] != group.Id+"/1.0.0" {
+		t.Errorf("expected group %s/1.0.0, got %v", group.Id, referenced.Groups)
+	}
+
+	_, detached, err := st.Delete(ctx, config.Id, "1.0.0", true)
+	if err != nil || len(detached) != 1 {
+		t.Fatalf("cascading Delete returned %v, %v", detached, err)
+	}
+	got, err := st.GetOneGroup(ctx, group.Id, "1.0.0")
+	if err != nil || len(got.Configs) != 0 {
+		t.Errorf("expected the config to be removed from the group, got %v, %v", got, err)
+	}
+	if configs, _ := st.Get(ctx, config.Id, "1.0.0"); len(configs) != 0 {
+		t.Errorf("expected the config to be deleted, got %v", configs)
+	}
+}
+
+func TestDeleteConfigHeldByRange(t *testing.T) {
+	ctx := context.Background()
+	st := store.NewMemory()
+
+	config, err := st.Config(ctx, &store.Config{Version: "1.0.0"})
+	if err != nil {
+		t.Fatalf("Config failed: %v", err)
+	}
+	if _, err := st.NewConfigVersion(ctx, &store.Config{Id: config.Id, Version: "1.1.0"}); err != nil {
+		t.Fatalf("NewConfigVersion failed: %v", err)
+	}
+	group, err := st.PostGroup(ctx, &store.Group{
+		Version: "1.0.0",
+		Mode:    store.ReferenceMode,
+		Refs:    []store.ConfigRef{{Id: config.Id, Version: "^1"}},
+	})
+	if err != nil {
+		t.Fatalf("PostGroup failed: %v", err)
+	}
+
+	// ^1 still resolves to 1.0.0 afterwards.
+	if _, _, err := st.Delete(ctx, config.Id, "1.1.0", false); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if _, _, err := st.Delete(ctx, config.Id, "1.0.0", false); !errors.Is(err, store.ErrReferenced) {
+		t.Fatalf("expected ErrReferenced, got %v", err)
+	}
+
+	if _, err := st.DeleteGroup(ctx, group.Id, "1.0.0"); err != nil {
+		t.Fatalf("DeleteGroup failed: %v", err)
+	}
+	if _, _, err := st.Delete(ctx, config.Id, "1.0.0", false); err != nil {
+		t.Errorf("Delete after DeleteGroup failed: %v", err)
+	}
+}
+
+func TestCascadeDeleteTooLarge(t *testing.T) {
+	ctx := context.Background()
+	st := store.NewMemory()
+
+	config, err := st.Config(ctx, &store.Config{Version: "1.0.0"})
+	if err != nil {
+		t.Fatalf("Config failed: %v", err)
+	}
+	for i := 0; i < 40; i++ {
+		if _, err := st.PostGroup(ctx, &store.Group{Version: "1.0.0", Configs: []store.Config{*config}}); err != nil {
+			t.Fatalf("PostGroup failed: %v", err)
+		}
+	}
+
+	if _, _, err := st.Delete(ctx, config.Id, "1.0.0", true); !errors.Is(err, store.ErrCascadeTooLarge) {
+		t.Fatalf("expected ErrCascadeTooLarge, got %v", err)
+	}
+	if configs, _ := st.Get(ctx, config.Id, "1.0.0"); len(configs) != 1 {
+		t.Errorf("expected the config to be kept, got %v", configs)
+	}
+}
+
+func TestGroupWriteChecksMembers(t *testing.T) {
+	ctx := context.Background()
+	st := store.NewMemory()
+
+	config, err := st.Config(ctx, &store.Config{Version: "1.0.0"})
+	if err != nil {
+		t.Fatalf("Config failed: %v", err)
+	}
+	if _, _, err := st.Delete(ctx, config.Id, "1.0.0", false); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+
+	// Both members were looked up before the config was deleted.
+	ref := store.ConfigRef{Id: config.Id, Version: "^1"}
+	if _, err := st.PostGroup(ctx, &store.Group{Version: "1.0.0", Mode: store.ReferenceMode, Refs: []store.ConfigRef{ref}}); !errors.Is(err, store.ErrConfigNotFound) {
+		t.Errorf("expected ErrConfigNotFound for a ref, got %v", err)
+	}
+	if _, err := st.PostGroup(ctx, &store.Group{Version: "1.0.0", Configs: []store.Config{*config}}); !errors.Is(err, store.ErrConflict) {
+		t.Errorf("expected ErrConflict for a copy, got %v", err)
+	}
+}
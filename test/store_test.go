@@ -0,0 +1,250 @@
+package test
+
+import (
+	"context"
+	"errors"
+	"example.com/mod/store"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestMemoryStoreConfigs(t *testing.T) {
+	ctx := context.Background()
+	st := store.NewMemory()
+
+	created, err := st.Config(ctx, &store.Config{Entries: map[string]string{"a": "1"}, Version: "v1"})
+	if err != nil {
+		t.Fatalf("Config failed: %v", err)
+	}
+	if _, err := st.Config(ctx, &store.Config{Entries: map[string]string{"b": "2"}, Version: "v1", Labels: store.Labels{"l1": ""}}); err != nil {
+		t.Fatalf("Config failed: %v", err)
+	}
+
+	configs, err := st.Get(ctx, created.Id, "v1")
+	if err != nil || len(configs) != 1 || configs[0].Entries["a"] != "1" {
+		t.Errorf("Get returned %v, %v", configs, err)
+	}
+
+	all, err := st.GetAll(ctx)
+	if err != nil || len(all) != 2 {
+		t.Errorf("GetAll returned %d configs, %v", len(all), err)
+	}
+
+	if _, _, err := st.Delete(ctx, created.Id, "v1", false); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if _, err := st.GetOneConfig(ctx, created.Id, "v1"); !errors.Is(err, store.ErrConfigNotFound) || !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("expected deleted config to be gone, got %v", err)
+	}
+}
+
+func TestMemoryStoreGroups(t *testing.T) {
+	ctx := context.Background()
+	st := store.NewMemory()
+
+	group, err := st.PostGroup(ctx, &store.Group{Version: "v1"})
+	if err != nil {
+		t.Fatalf("PostGroup failed: %v", err)
+	}
+
+	group.Configs = append(group.Configs, store.Config{Id: "c1", Version: "v1"})
+	if _, err := st.SaveGroup(ctx, group); err != nil {
+		t.Fatalf("SaveGroup failed: %v", err)
+	}
+
+	got, err := st.GetOneGroup2(ctx, group.Id)
+	if err != nil || len(got.Configs) != 1 {
+		t.Errorf("GetOneGroup2 returned %v, %v", got, err)
+	}
+
+	if _, err := st.DeleteGroupId(ctx, group.Id); err != nil {
+		t.Fatalf("DeleteGroupId failed: %v", err)
+	}
+	groups, err := st.GetAllGroups(ctx)
+	if err != nil || len(groups) != 0 {
+		t.Errorf("GetAllGroups returned %d groups, %v", len(groups), err)
+	}
+}
+
+func TestBoltStoreSurvivesRestart(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "alati.db")
+
+	st, err := store.NewBolt(path)
+	if err != nil {
+		t.Fatalf("NewBolt failed: %v", err)
+	}
+	created, err := st.Config(ctx, &store.Config{Entries: map[string]string{"a": "1"}, Version: "v1"})
+	if err != nil {
+		t.Fatalf("Config failed: %v", err)
+	}
+	expires := time.Now().Add(time.Hour)
+	_, claim, err := st.ReserveIdempotencyKey(ctx, "POST /config/", "key-1", "sum", expires)
+	if err != nil {
+		t.Fatalf("ReserveIdempotencyKey failed: %v", err)
+	}
+	response := &store.IdempotentResponse{Status: 201, Body: []byte("{}"), Fingerprint: "sum", ExpiresAt: expires}
+	if err := st.CompleteIdempotencyKey(ctx, "POST /config/", "key-1", claim, response); err != nil {
+		t.Fatalf("CompleteIdempotencyKey failed: %v", err)
+	}
+	if err := st.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	st, err = store.NewBolt(path)
+	if err != nil {
+		t.Fatalf("NewBolt failed: %v", err)
+	}
+	defer st.Close()
+
+	got, err := st.GetOneConfig(ctx, created.Id, "v1")
+	if err != nil || got.Entries["a"] != "1" {
+		t.Errorf("GetOneConfig returned %v, %v", got, err)
+	}
+	stored, _, err := st.ReserveIdempotencyKey(ctx, "POST /config/", "key-1", "sum", expires)
+	if err != nil || stored == nil || stored.Status != 201 || string(stored.Body) != "{}" {
+		t.Errorf("expected the stored response, got %v, %v", stored, err)
+	}
+}
+
+func TestSaveGroupRejectsStaleIndex(t *testing.T) {
+	ctx := context.Background()
+	st := store.NewMemory()
+
+	group, err := st.PostGroup(ctx, &store.Group{Version: "v1"})
+	if err != nil {
+		t.Fatalf("PostGroup failed: %v", err)
+	}
+	first, _ := st.GetOneGroup(ctx, group.Id, "v1")
+	second, _ := st.GetOneGroup(ctx, group.Id, "v1")
+
+	first.Configs = append(first.Configs, store.Config{Id: "c1"})
+	if _, err := st.SaveGroup(ctx, first); err != nil {
+		t.Fatalf("SaveGroup failed: %v", err)
+	}
+	second.Configs = append(second.Configs, store.Config{Id: "c2"})
+	if _, err := st.SaveGroup(ctx, second); !errors.Is(err, store.ErrConflict) {
+		t.Errorf("expected ErrConflict, got %v", err)
+	}
+}
+
+func TestDeleteConfigsRejectsStaleIndex(t *testing.T) {
+	ctx := context.Background()
+	st := store.NewMemory()
+
+	config, err := st.Config(ctx, &store.Config{Version: "1.0.0-draft", Labels: store.Labels{"env": "dev"}})
+	if err != nil {
+		t.Fatalf("Config failed: %v", err)
+	}
+	read, _ := st.Get(ctx, config.Id, config.Version)
+	if _, err := st.UpdateDraft(ctx, read[0], &store.Config{Labels: store.Labels{"env": "dev"}}); err != nil {
+		t.Fatalf("UpdateDraft failed: %v", err)
+	}
+	if _, err := st.DeleteConfigs(ctx, read, false); !errors.Is(err, store.ErrConflict) {
+		t.Errorf("expected ErrConflict, got %v", err)
+	}
+
+	current, _ := st.Get(ctx, config.Id, config.Version)
+	if _, err := st.DeleteConfigs(ctx, current, false); err != nil {
+		t.Fatalf("DeleteConfigs failed: %v", err)
+	}
+	if left, _ := st.Get(ctx, config.Id, config.Version); len(left) != 0 {
+		t.Errorf("expected the config to be deleted, got %v", left)
+	}
+}
+
+func TestMemoryStoreWaitConfigs(t *testing.T) {
+	ctx := context.Background()
+	st := store.NewMemory()
+
+	created, err := st.Config(ctx, &store.Config{Entries: map[string]string{"a": "1"}, Version: "v1"})
+	if err != nil {
+		t.Fatalf("Config failed: %v", err)
+	}
+	_, index, err := st.WaitConfigs(ctx, created.Id, "v1", 0, 0)
+	if err != nil || index == 0 {
+		t.Fatalf("WaitConfigs returned index %d, %v", index, err)
+	}
+
+	done := make(chan []*store.Config)
+	go func() {
+		configs, _, _ := st.WaitConfigs(ctx, created.Id, "v1", index, 5*time.Second)
+		done <- configs
+	}()
+
+	select {
+	case <-done:
+		t.Fatalf("WaitConfigs returned before anything changed")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	if _, _, err := st.Delete(ctx, created.Id, "v1", false); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	select {
+	case configs := <-done:
+		if len(configs) != 0 {
+			t.Errorf("expected no configs after delete, got %d", len(configs))
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("WaitConfigs did not return after delete")
+	}
+}
+
+func TestIdempotencyKeys(t *testing.T) {
+	ctx := context.Background()
+	st := store.NewMemory()
+
+	expires := time.Now().Add(time.Hour)
+	if stored, _, err := st.ReserveIdempotencyKey(ctx, "POST /group/", "key-1", "sum", expires); err != nil || stored != nil {
+		t.Fatalf("ReserveIdempotencyKey returned %v, %v", stored, err)
+	}
+	stored, _, err := st.ReserveIdempotencyKey(ctx, "POST /group/", "key-1", "sum", expires)
+	if err != nil || stored == nil || stored.Status != 0 {
+		t.Errorf("expected the pending reservation, got %v, %v", stored, err)
+	}
+	// A reservation whose lease ran out, its request never finished, is
+	// taken over.
+	stored, lost, err := st.ReserveIdempotencyKey(ctx, "PUT /group/", "key-1", "sum", time.Now().Add(-time.Second))
+	if err != nil || stored != nil {
+		t.Fatalf("ReserveIdempotencyKey returned %v, %v", stored, err)
+	}
+	stored, claim, err := st.ReserveIdempotencyKey(ctx, "PUT /group/", "key-1", "sum", expires)
+	if err != nil || stored != nil {
+		t.Errorf("expected the expired lease to be taken over, got %v, %v", stored, err)
+	}
+	// The request that lost its claim can neither renew nor complete it.
+	if _, err := st.RenewIdempotencyKey(ctx, "PUT /group/", "key-1", lost, "sum", expires); !errors.Is(err, store.ErrConflict) {
+		t.Errorf("expected renewing a lost claim to fail with ErrConflict, got %v", err)
+	}
+	response := &store.IdempotentResponse{Status: 200, Fingerprint: "sum", ExpiresAt: expires}
+	if err := st.CompleteIdempotencyKey(ctx, "PUT /group/", "key-1", lost, response); !errors.Is(err, store.ErrConflict) {
+		t.Errorf("expected completing a lost claim to fail with ErrConflict, got %v", err)
+	}
+	if claim, err = st.RenewIdempotencyKey(ctx, "PUT /group/", "key-1", claim, "sum", expires); err != nil {
+		t.Fatalf("RenewIdempotencyKey failed: %v", err)
+	}
+	if err := st.CompleteIdempotencyKey(ctx, "PUT /group/", "key-1", claim, response); err != nil {
+		t.Errorf("CompleteIdempotencyKey failed: %v", err)
+	}
+	// Keys are scoped, the same key on another route is a new request.
+	if stored, _, err := st.ReserveIdempotencyKey(ctx, "POST /config/", "key-1", "sum", expires); err != nil || stored != nil {
+		t.Errorf("ReserveIdempotencyKey in another scope returned %v, %v", stored, err)
+	}
+
+	_, claim, err = st.ReserveIdempotencyKey(ctx, "POST /group/", "key-2", "sum", expires)
+	if err != nil {
+		t.Fatalf("ReserveIdempotencyKey failed: %v", err)
+	}
+	if err := st.ReleaseIdempotencyKey(ctx, "POST /group/", "key-2", claim); err != nil {
+		t.Fatalf("ReleaseIdempotencyKey failed: %v", err)
+	}
+	if stored, _, err := st.ReserveIdempotencyKey(ctx, "POST /group/", "key-2", "sum", time.Now().Add(-time.Second)); err != nil || stored != nil {
+		t.Fatalf("ReserveIdempotencyKey after release returned %v, %v", stored, err)
+	}
+	n, err := st.ExpireIdempotencyKeys(ctx, time.Now())
+	if err != nil || n != 1 {
+		t.Errorf("ExpireIdempotencyKeys = %d, %v, want 1", n, err)
+	}
+}
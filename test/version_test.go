@@ -0,0 +1,250 @@
+package test
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"example.com/mod/store"
+	bolt "go.etcd.io/bbolt"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestResolveConfigVersion(t *testing.T) {
+	ctx := context.Background()
+	st := store.NewMemory()
+
+	config, err := st.Config(ctx, &store.Config{Version: "1.4.2"})
+	if err != nil {
+		t.Fatalf("Config failed: %v", err)
+	}
+
+	cases := map[string]string{
+		"latest": "1.4.2",
+		"^1.2":   "1.4.2",
+		"~1.4.0": "1.4.2",
+		"1.4.2":  "1.4.2",
+	}
+	for query, want := range cases {
+		got, err := st.ResolveConfigVersion(ctx, config.Id, query)
+		if err != nil || got != want {
+			t.Errorf("ResolveConfigVersion(%q) = %q, %v, want %q", query, got, err, want)
+		}
+	}
+
+	if _, err := st.ResolveConfigVersion(ctx, config.Id, "^2"); !errors.Is(err, store.ErrVersionNotFound) {
+		t.Errorf("expected ErrVersionNotFound, got %v", err)
+	}
+	if _, err := st.Config(ctx, &store.Config{Version: "not-a-version"}); !errors.Is(err, store.ErrInvalidVersion) {
+		t.Errorf("expected ErrInvalidVersion, got %v", err)
+	}
+}
+
+func TestIsVersionRange(t *testing.T) {
+	for version, want := range map[string]bool{
+		"latest": true,
+		"^1.2":   true,
+		"~1.4.0": true,
+		">=2":    true,
+		"1.2.3":  false,
+		"v1":     false,
+	} {
+		if got := store.IsVersionRange(version); got != want {
+			t.Errorf("IsVersionRange(%q) = %v, want %v", version, got, want)
+		}
+	}
+}
+
+func TestNewConfigVersion(t *testing.T) {
+	ctx := context.Background()
+	st := store.NewMemory()
+
+	first, err := st.Config(ctx, &store.Config{Version: "1.0.1", Entries: map[string]string{"a": "1"}})
+	if err != nil {
+		t.Fatalf("Config failed: %v", err)
+	}
+	for _, version := range []string{"1.0.10", "1.2.0", "2.0.0-rc.1"} {
+		if _, err := st.NewConfigVersion(ctx, &store.Config{Id: first.Id, Version: version}); err != nil {
+			t.Fatalf("NewConfigVersion(%s) failed: %v", version, err)
+		}
+	}
+
+	if _, err := st.NewConfigVersion(ctx, &store.Config{Id: first.Id, Version: "v1.0.1"}); !errors.Is(err, store.ErrVersionExists) {
+		t.Errorf("expected ErrVersionExists, got %v", err)
+	}
+	if _, err := st.NewConfigVersion(ctx, &store.Config{Id: "missing", Version: "1.0.0"}); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+
+	versions, err := st.ConfigVersions(ctx, first.Id)
+	if err != nil || !reflect.DeepEqual(versions, []string{"1.0.1", "1.0.10", "1.2.0", "2.0.0-rc.1"}) {
+		t.Errorf("ConfigVersions returned %v, %v", versions, err)
+	}
+	if latest, err := st.ResolveConfigVersion(ctx, first.Id, "latest"); err != nil || latest != "1.2.0" {
+		t.Errorf("latest resolved to %q, %v", latest, err)
+	}
+	if patch, err := st.ResolveConfigVersion(ctx, first.Id, "~1.0.1"); err != nil || patch != "1.0.10" {
+		t.Errorf("~1.0.1 resolved to %q, %v", patch, err)
+	}
+
+	// 1.0.1 must not pick up 1.0.10, which shares its key prefix.
+	configs, err := st.Get(ctx, first.Id, "1.0.1")
+	if err != nil || len(configs) != 1 || configs[0].Entries["a"] != "1" {
+		t.Errorf("Get returned %v, %v", configs, err)
+	}
+
+	// v1.0.1 is stored and looked up as 1.0.1.
+	tagged, err := st.Config(ctx, &store.Config{Version: "v1.0.1"})
+	if err != nil || tagged.Version != "1.0.1" {
+		t.Fatalf("Config returned %v, %v", tagged, err)
+	}
+	if configs, err := st.Get(ctx, tagged.Id, "v1.0.1"); err != nil || len(configs) != 1 {
+		t.Errorf("Get(v1.0.1) returned %v, %v", configs, err)
+	}
+}
+
+func TestGroupVersionsAreImmutable(t *testing.T) {
+	ctx := context.Background()
+	st := store.NewMemory()
+
+	group, err := st.PostGroup(ctx, &store.Group{Version: "1.0.0"})
+	if err != nil {
+		t.Fatalf("PostGroup failed: %v", err)
+	}
+	if _, err := st.NewGroupVersion(ctx, &store.Group{Id: group.Id, Version: "1.1.0"}); err != nil {
+		t.Fatalf("NewGroupVersion failed: %v", err)
+	}
+	if _, err := st.NewGroupVersion(ctx, &store.Group{Id: group.Id, Version: "1.1.0"}); !errors.Is(err, store.ErrVersionExists) {
+		t.Errorf("expected ErrVersionExists, got %v", err)
+	}
+	if _, err := st.SaveGroup(ctx, &store.Group{Id: group.Id, Version: "1.0.0"}); !errors.Is(err, store.ErrVersionExists) {
+		t.Errorf("expected blind overwrite to fail with ErrVersionExists, got %v", err)
+	}
+}
+
+func TestUpdateDraft(t *testing.T) {
+	ctx := context.Background()
+	st := store.NewMemory()
+
+	draft, err := st.Config(ctx, &store.Config{Version: "1.1.0-draft", Entries: map[string]string{"a": "1"}})
+	if err != nil {
+		t.Fatalf("Config failed: %v", err)
+	}
+	updated, err := st.UpdateDraft(ctx, draft, &store.Config{Entries: map[string]string{"a": "2"}, Labels: store.Labels{"env": "dev"}})
+	if err != nil {
+		t.Fatalf("UpdateDraft failed: %v", err)
+	}
+	configs, err := st.Get(ctx, draft.Id, draft.Version)
+	if err != nil || len(configs) != 1 || configs[0].Entries["a"] != "2" || configs[0].Labels["env"] != "dev" {
+		t.Fatalf("expected the patched draft only, got %v, %v", configs, err)
+	}
+	rc, err := st.NewConfigVersion(ctx, &store.Config{Id: draft.Id, Version: "1.1.0-rc.1"})
+	if err != nil {
+		t.Fatalf("NewConfigVersion failed: %v", err)
+	}
+	if _, err := st.UpdateDraft(ctx, rc, &store.Config{}); !errors.Is(err, store.ErrVersionExists) {
+		t.Errorf("expected ErrVersionExists for a release candidate, got %v", err)
+	}
+	if _, err := st.UpdateDraft(ctx, draft, &store.Config{}); !errors.Is(err, store.ErrConflict) {
+		t.Errorf("expected ErrConflict for a stale draft, got %v", err)
+	}
+	if _, err := st.UpdateDraft(ctx, updated, &store.Config{}); err != nil {
+		t.Errorf("UpdateDraft failed: %v", err)
+	}
+
+	stable, err := st.NewConfigVersion(ctx, &store.Config{Id: draft.Id, Version: "1.0.0"})
+	if err != nil {
+		t.Fatalf("NewConfigVersion failed: %v", err)
+	}
+	if _, err := st.UpdateDraft(ctx, stable, &store.Config{}); !errors.Is(err, store.ErrVersionExists) {
+		t.Errorf("expected ErrVersionExists for a published version, got %v", err)
+	}
+
+	next, err := store.NextPatchVersion([]string{"1.0.0", "1.0.4", "1.1.0-draft", "2.0.0"}, "1.0.0")
+	if err != nil || next != "1.0.5" {
+		t.Errorf("NextPatchVersion = %q, %v, want 1.0.5", next, err)
+	}
+}
+
+func TestBoltMigratesLegacyVersions(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "alati.db")
+	st, err := store.NewBolt(path)
+	if err != nil {
+		t.Fatalf("NewBolt failed: %v", err)
+	}
+	st.Close()
+
+	// Written like a release that stored versions as they were sent.
+	legacy := map[string]string{
+		"configs/c1/v1":                `{"id":"c1","entries":{"a":"1"},"version":"v1"}`,
+		"configs/c1/1.1/env=prod":      `{"id":"c1","entries":{"a":"2"},"labels":{"env":"prod"},"version":"1.1"}`,
+		"index/labels/env/prod/c1/1.1": "configs/c1/1.1/env=prod",
+		"groups/g1/v2/":                `{"id":"g1","configs":[{"id":"c1","entries":{"a":"1"},"version":"v1"}],"version":"v2"}`,
+		"index/groups/c1":              `["groups/g1/v2/"]`,
+	}
+	db, err := bolt.Open(path, 0600, nil)
+	if err != nil {
+		t.Fatalf("bolt.Open failed: %v", err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for key, value := range legacy {
+			index, err := tx.Bucket([]byte("kv")).NextSequence()
+			if err != nil {
+				return err
+			}
+			encoded := make([]byte, 8)
+			binary.BigEndian.PutUint64(encoded, index)
+			if err := tx.Bucket([]byte("kv")).Put([]byte(key), []byte(value)); err != nil {
+				return err
+			}
+			if err := tx.Bucket([]byte("index")).Put([]byte(key), encoded); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	db.Close()
+	if err != nil {
+		t.Fatalf("writing legacy keys failed: %v", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if st, err = store.NewBolt(path); err != nil {
+			t.Fatalf("NewBolt failed: %v", err)
+		}
+		if i == 0 {
+			st.Close()
+		}
+	}
+	defer st.Close()
+
+	configs, err := st.Get(ctx, "c1", "1.0.0")
+	if err != nil || len(configs) != 1 || configs[0].Version != "1.0.0" {
+		t.Errorf("Get(1.0.0) returned %v, %v", configs, err)
+	}
+	configs, err = st.GetConfigsByLabels(ctx, "c1", "1.1", "env=prod")
+	if err != nil || len(configs) != 1 || configs[0].Entries["a"] != "2" {
+		t.Errorf("GetConfigsByLabels(1.1) returned %v, %v", configs, err)
+	}
+	configs, err = st.FindConfigsByLabels(ctx, store.Labels{"env": "prod"})
+	if err != nil || len(configs) != 1 || configs[0].Version != "1.1.0" {
+		t.Errorf("FindConfigsByLabels returned %v, %v", configs, err)
+	}
+
+	groups, err := st.GetGroup(ctx, "g1", "v2")
+	if err != nil || len(groups) != 1 || groups[0].Version != "2.0.0" || groups[0].Configs[0].Version != "1.0.0" {
+		t.Fatalf("GetGroup(v2) returned %v, %v", groups, err)
+	}
+	// The reverse index follows the group to its new key.
+	if _, _, err := st.Delete(ctx, "c1", "1.0.0", false); !errors.Is(err, store.ErrReferenced) {
+		t.Errorf("expected ErrReferenced, got %v", err)
+	}
+	if _, err := st.DeleteGroup(ctx, "g1", "2.0.0"); err != nil {
+		t.Errorf("DeleteGroup failed: %v", err)
+	}
+	if _, _, err := st.Delete(ctx, "c1", "1", false); err != nil {
+		t.Errorf("Delete failed: %v", err)
+	}
+}
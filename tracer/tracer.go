@@ -94,3 +94,12 @@ func LogString(key string, value string) log.Field {
 func LogError(span opentracing.Span, err error, fields ...log.Field) {
 	ext.LogError(span, err, fields...)
 }
+
+// TraceID returns the id of the trace span belongs to, or "" for spans not
+// recorded by Jaeger.
+func TraceID(span opentracing.Span) string {
+	if sc, ok := span.Context().(jaeger.SpanContext); ok {
+		return sc.TraceID().String()
+	}
+	return ""
+}
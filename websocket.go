@@ -0,0 +1,212 @@
+package main
+
+import (
+	"context"
+	s "example.com/mod/store"
+	tracer "example.com/mod/tracer"
+	"fmt"
+	"github.com/gorilla/websocket"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	wsWriteWait  = 10 * time.Second
+	wsPongWait   = 60 * time.Second
+	wsPingPeriod = wsPongWait * 9 / 10
+)
+
+var upgrader = websocket.Upgrader{
+	CheckOrigin: checkOrigin(os.Getenv("WS_ALLOWED_ORIGINS")),
+}
+
+// checkOrigin accepts the origins in allowed, a comma separated list like
+// https://a.example,https://b.example. Without a list only same-origin
+// requests are upgraded, as gorilla/websocket does by default.
+func checkOrigin(allowed string) func(r *http.Request) bool {
+	if allowed == "" {
+		return nil
+	}
+	origins := map[string]bool{}
+	for _, origin := range strings.Split(allowed, ",") {
+		origins[strings.ToLower(strings.TrimSpace(origin))] = true
+	}
+	return func(r *http.Request) bool {
+		origin := r.Header.Get("Origin")
+		return origin == "" || origins[strings.ToLower(origin)]
+	}
+}
+
+// GroupSubscription is sent by the client to change the set of groups it
+// receives updates for.
+type GroupSubscription struct {
+	// subscribe or unsubscribe
+	Action string   `json:"action"`
+	Groups []string `json:"groups"`
+}
+
+// GroupMessage is sent to the client with the full group document every
+// time the group's membership or one of its member configs changes.
+type GroupMessage struct {
+	// group, deleted or error
+	Type    string   `json:"type"`
+	Id      string   `json:"id,omitempty"`
+	Version string   `json:"version,omitempty"`
+	Group   *s.Group `json:"group,omitempty"`
+	Error   string   `json:"error,omitempty"`
+}
+
+// swagger:route GET /groups/subscribe/ group subscribeGroups
+// Subscribe to group membership changes over a WebSocket
+//
+// responses:
+//
+//	400: ErrorResponse
+//	101: NoContentResponse
+func (cs *configServer) groupSubscribeHandler(w http.ResponseWriter, req *http.Request) {
+	conn, err := upgrader.Upgrade(w, req, nil)
+	if err != nil {
+		// Upgrade already replied with an error status.
+		log.Println(err)
+		return
+	}
+	defer conn.Close()
+
+	ctx, cancel := context.WithCancel(req.Context())
+	defer cancel()
+
+	events, unsubscribe := cs.events.subscribe()
+	defer unsubscribe()
+
+	subscriptions := make(chan GroupSubscription)
+	go func() {
+		defer cancel()
+		conn.SetReadDeadline(time.Now().Add(wsPongWait))
+		conn.SetPongHandler(func(string) error {
+			return conn.SetReadDeadline(time.Now().Add(wsPongWait))
+		})
+		for {
+			var sub GroupSubscription
+			if err := conn.ReadJSON(&sub); err != nil {
+				return
+			}
+			select {
+			case subscriptions <- sub:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	subscribed := map[string]bool{}
+	send := func(msg GroupMessage) bool {
+		conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+		return conn.WriteJSON(msg) == nil
+	}
+	// sendGroup resolves the refs of reference mode groups like GET with
+	// expand does, on a copy since events are shared between subscribers.
+	sendGroup := func(ctx context.Context, group *s.Group) bool {
+		resolved := *group
+		if _, err := cs.resolveGroups(ctx, []*s.Group{&resolved}, true); err != nil {
+			return send(GroupMessage{Type: "error", Id: group.Id, Version: group.Version, Error: err.Error()})
+		}
+		return send(GroupMessage{Type: "group", Id: group.Id, Version: group.Version, Group: &resolved})
+	}
+	sendGroups := func(id string, onlyWithConfig string) bool {
+		span := tracer.StartSpanFromContext(ctx, "groupSubscription")
+		defer span.Finish()
+
+		ctx := tracer.ContextWithSpan(ctx, span)
+		groups, err := cs.store.GetGroupId(ctx, id)
+		if err != nil {
+			return send(GroupMessage{Type: "error", Id: id, Error: err.Error()})
+		}
+		for _, group := range groups {
+			if onlyWithConfig != "" && !groupHasConfig(group, onlyWithConfig) {
+				continue
+			}
+			if !sendGroup(ctx, group) {
+				return false
+			}
+		}
+		return true
+	}
+	subscribe := func(ids []string) bool {
+		for _, id := range ids {
+			if id == "" || subscribed[id] {
+				continue
+			}
+			subscribed[id] = true
+			if !sendGroups(id, "") {
+				return false
+			}
+		}
+		return true
+	}
+
+	if groups := req.URL.Query().Get("groups"); groups != "" {
+		if !subscribe(strings.Split(groups, ",")) {
+			return
+		}
+	}
+
+	ping := time.NewTicker(wsPingPeriod)
+	defer ping.Stop()
+
+	for {
+		ok := true
+		select {
+		case sub := <-subscriptions:
+			switch sub.Action {
+			case "subscribe":
+				ok = subscribe(sub.Groups)
+			case "unsubscribe":
+				for _, id := range sub.Groups {
+					delete(subscribed, id)
+				}
+			default:
+				ok = send(GroupMessage{Type: "error", Error: fmt.Sprintf("unknown action %q", sub.Action)})
+			}
+		case e := <-events:
+			switch {
+			case e.Kind == groupKind && subscribed[e.Id] && e.Type == eventDeleted:
+				ok = send(GroupMessage{Type: "deleted", Id: e.Id, Version: e.Version})
+			case e.Kind == groupKind && subscribed[e.Id]:
+				ok = sendGroup(ctx, e.Group)
+			case e.Kind == configKind:
+				for id := range subscribed {
+					if ok = sendGroups(id, e.Id); !ok {
+						break
+					}
+				}
+			}
+		case <-ping.C:
+			conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+			ok = conn.WriteMessage(websocket.PingMessage, nil) == nil
+		case <-ctx.Done():
+			return
+		}
+		if !ok {
+			return
+		}
+	}
+}
+
+// groupHasConfig reports whether config id is a member of group, copied
+// or referenced.
+func groupHasConfig(group *s.Group, id string) bool {
+	for _, ref := range group.Refs {
+		if ref.Id == id {
+			return true
+		}
+	}
+	for _, config := range group.Configs {
+		if config.Id == id {
+			return true
+		}
+	}
+	return false
+}
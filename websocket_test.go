@@ -0,0 +1,97 @@
+package main
+
+import (
+	"github.com/gorilla/websocket"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGroupSubscription(t *testing.T) {
+	_, ts := newTestServer(t)
+	group := createGroup(t, ts, `{"version":"1.0.0"}`)
+	config := createConfig(t, ts, `{"version":"1.0.0","entries":{"a":"1"}}`)
+
+	url := "ws" + strings.TrimPrefix(ts.URL, "http") + "/groups/subscribe/?groups=" + group.Id
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	read := func() GroupMessage {
+		t.Helper()
+		var msg GroupMessage
+		if err := conn.ReadJSON(&msg); err != nil {
+			t.Fatalf("ReadJSON failed: %v", err)
+		}
+		return msg
+	}
+
+	if msg := read(); msg.Type != "group" || msg.Id != group.Id || len(msg.Group.Configs) != 0 {
+		t.Fatalf("expected the group on subscribe, got %+v", msg)
+	}
+	if resp, data := do(t, http.MethodPut, ts.URL+"/group/"+group.Id+"/1.0.0/config/"+config.Id+"/1.0.0/", "", nil); resp.StatusCode != http.StatusOK {
+		t.Fatalf("adding the config returned %d: %s", resp.StatusCode, data)
+	}
+	if msg := read(); msg.Type != "group" || msg.Group == nil || len(msg.Group.Configs) != 1 || msg.Group.Configs[0].Id != config.Id {
+		t.Fatalf("expected the group with its new member, got %+v", msg)
+	}
+
+	// Subscriptions are handled in order, so the error for the unknown
+	// action follows the unsubscribe.
+	for _, sub := range []GroupSubscription{{Action: "unsubscribe", Groups: []string{group.Id}}, {Action: "list"}} {
+		if err := conn.WriteJSON(sub); err != nil {
+			t.Fatalf("WriteJSON failed: %v", err)
+		}
+	}
+	if msg := read(); msg.Type != "error" {
+		t.Fatalf("expected an error for the unknown action, got %+v", msg)
+	}
+	if resp, data := do(t, http.MethodDelete, ts.URL+"/group/"+group.Id+"/1.0.0/", "", nil); resp.StatusCode != http.StatusOK {
+		t.Fatalf("DELETE group returned %d: %s", resp.StatusCode, data)
+	}
+	if err := conn.WriteJSON(GroupSubscription{Action: "list"}); err != nil {
+		t.Fatalf("WriteJSON failed: %v", err)
+	}
+	if msg := read(); msg.Type != "error" {
+		t.Errorf("expected no message for the unsubscribed group, got %+v", msg)
+	}
+}
+
+func TestGroupSubscriptionOrigin(t *testing.T) {
+	_, ts := newTestServer(t)
+	url := "ws" + strings.TrimPrefix(ts.URL, "http") + "/groups/subscribe/"
+
+	// Without WS_ALLOWED_ORIGINS only the server's own origin is upgraded.
+	_, resp, err := websocket.DefaultDialer.Dial(url, http.Header{"Origin": {"https://a.example"}})
+	if err == nil || resp == nil || resp.StatusCode != http.StatusForbidden {
+		t.Errorf("expected a foreign origin to be refused with 403, got %v", err)
+	}
+	conn, _, err := websocket.DefaultDialer.Dial(url, http.Header{"Origin": {ts.URL}})
+	if err != nil {
+		t.Fatalf("Dial from the same origin failed: %v", err)
+	}
+	conn.Close()
+
+	check := checkOrigin("https://a.example, https://B.example")
+	for origin, want := range map[string]bool{
+		"https://a.example": true,
+		"https://b.example": true,
+		"https://c.example": false,
+		"":                  true,
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/groups/subscribe/", nil)
+		if origin != "" {
+			req.Header.Set("Origin", origin)
+		}
+		if got := check(req); got != want {
+			t.Errorf("checkOrigin(%q) = %v, want %v", origin, got, want)
+		}
+	}
+	if checkOrigin("") != nil {
+		t.Error("expected the default same-origin check without a list")
+	}
+}